@@ -11,18 +11,21 @@ set -eux
 buildDir='build'
 mkdir -p "${buildDir}"
 
-filename='packer-provisioner-breadcrumbs'
-if [[ ! -z "${GOOS+x}" ]]
-then
-    filename="${filename}-${GOOS}"
-fi
-if [[ ! -z "${GOARCH+x}" ]]
-then
-    filename="${filename}-${GOARCH}"
-fi
-if [[ ! -z "${GOOS+x}" ]] && [[ "${GOOS}" == "windows" ]]
-then
-    filename="${filename}.exe"
-fi
+for command in packer-provisioner-breadcrumbs breadcrumbs
+do
+    filename="${command}"
+    if [[ ! -z "${GOOS+x}" ]]
+    then
+        filename="${filename}-${GOOS}"
+    fi
+    if [[ ! -z "${GOARCH+x}" ]]
+    then
+        filename="${filename}-${GOARCH}"
+    fi
+    if [[ ! -z "${GOOS+x}" ]] && [[ "${GOOS}" == "windows" ]]
+    then
+        filename="${filename}.exe"
+    fi
 
-go build -ldflags "-X main.version=${VERSION}" -o "${buildDir}/${filename}" cmd/packer-provisioner-breadcrumbs/main.go
+    go build -ldflags "-X main.version=${VERSION}" -o "${buildDir}/${filename}" "./cmd/${command}"
+done
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/stephen-fox/packer-breadcrumbs"
+)
+
+const (
+	// exitOk means the command succeeded, and (when verifying) that
+	// no problems were found or (when diffing) that no differences
+	// were found.
+	exitOk = 0
+
+	// exitFailed means that the breadcrumbs were found to have been
+	// tampered with (e.g., missing, modified, or extra files), or
+	// that the diffed breadcrumbs differ.
+	exitFailed = 1
+
+	// exitError means that the command could not be run (e.g., due
+	// to invalid usage or an unreadable manifest).
+	exitError = 2
+
+	usage = `breadcrumbs - inspect breadcrumbs created by the packer plugin
+
+usage: breadcrumbs <command> [options]
+
+commands:
+    verify    Verify the files in a breadcrumbs directory or archive
+    diff      Compare two breadcrumbs directories or archives
+    version   Print the version and exit
+
+exit codes:
+    0    Success
+    1    Verification failed (missing, modified, or extra files, or
+         an invalid signature), or the diffed breadcrumbs differ
+    2    The command could not be run
+`
+)
+
+var (
+	version string
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		os.Stderr.WriteString(usage)
+		os.Exit(exitError)
+	}
+
+	var exitCode int
+
+	switch os.Args[1] {
+	case "verify":
+		exitCode = verify(os.Args[2:], os.Stdout, os.Stderr)
+	case "diff":
+		exitCode = diff(os.Args[2:], os.Stdout, os.Stderr)
+	case "version":
+		fmt.Println(version)
+	case "-h", "--help", "help":
+		os.Stdout.WriteString(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s", os.Args[1], usage)
+		exitCode = exitError
+	}
+
+	os.Exit(exitCode)
+}
+
+func verify(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: breadcrumbs verify [options] <breadcrumbs-path>\n\noptions:")
+		flags.PrintDefaults()
+	}
+	jsonOutput := flags.Bool("json", false, "Print the result as JSON")
+	publicKeyPath := flags.String("public-key", "",
+		"Verify the manifest's signature using this ed25519 public key file (PEM or OpenSSH format)")
+	strict := flags.Bool("strict", false, "Fail if any files have no recorded hash")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return exitError
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	b, err := breadcrumbs.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to open breadcrumbs - %s\n", err.Error())
+		return exitError
+	}
+	defer b.Close()
+
+	result, err := breadcrumbs.Verify(b)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to verify breadcrumbs - %s\n", err.Error())
+		return exitError
+	}
+
+	if len(*publicKeyPath) > 0 {
+		publicKey, err := breadcrumbs.LoadPublicKey(*publicKeyPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load public key - %s\n", err.Error())
+			return exitError
+		}
+
+		result.Signature, err = breadcrumbs.VerifySignature(b, publicKey)
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to verify signature - %s\n", err.Error())
+			return exitError
+		}
+	}
+
+	if *jsonOutput {
+		raw, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to encode result - %s\n", err.Error())
+			return exitError
+		}
+
+		fmt.Fprintf(stdout, "%s\n", raw)
+	} else {
+		for _, m := range result.Missing {
+			fmt.Fprintf(stdout, "missing: %s (%s)\n", m.StoredAtPath, m.FoundAtPath)
+		}
+
+		for _, m := range result.Modified {
+			fmt.Fprintf(stdout, "modified: %s (%s) - expected sha256 %s, got %s\n",
+				m.StoredAtPath, m.FoundAtPath, m.Sha256, m.ActualSha256)
+		}
+
+		for _, e := range result.Extra {
+			fmt.Fprintf(stdout, "extra: %s\n", e)
+		}
+
+		for _, u := range result.Unverifiable {
+			fmt.Fprintf(stdout, "unverifiable (no recorded hash): %s (%s)\n", u.StoredAtPath, u.FoundAtPath)
+		}
+
+		if len(result.Signature) > 0 {
+			fmt.Fprintf(stdout, "signature: %s\n", result.Signature)
+		}
+	}
+
+	ok := result.Ok()
+	if *strict {
+		ok = result.OkStrict()
+	}
+
+	if !ok {
+		if !*jsonOutput {
+			fmt.Fprintln(stdout, "verification failed")
+		}
+		return exitFailed
+	}
+
+	if !*jsonOutput {
+		fmt.Fprintln(stdout, "ok")
+	}
+
+	return exitOk
+}
+
+func diff(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: breadcrumbs diff [options] <old-breadcrumbs-path> <new-breadcrumbs-path>\n\noptions:")
+		flags.PrintDefaults()
+	}
+	jsonOutput := flags.Bool("json", false, "Print the result as JSON")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return exitError
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitError
+	}
+
+	result, err := breadcrumbs.DiffDirs(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to diff breadcrumbs - %s\n", err.Error())
+		return exitError
+	}
+
+	if *jsonOutput {
+		raw, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to encode result - %s\n", err.Error())
+			return exitError
+		}
+
+		fmt.Fprintf(stdout, "%s\n", raw)
+	} else {
+		for _, f := range result.Fields {
+			fmt.Fprintf(stdout, "%s: '%s' -> '%s'\n", f.Name, f.Old, f.New)
+		}
+
+		for _, v := range result.UserVars {
+			switch v.Kind {
+			case breadcrumbs.Added:
+				fmt.Fprintf(stdout, "user variable added: %s = '%s'\n", v.Name, v.New)
+			case breadcrumbs.Removed:
+				fmt.Fprintf(stdout, "user variable removed: %s = '%s'\n", v.Name, v.Old)
+			default:
+				fmt.Fprintf(stdout, "user variable changed: %s: '%s' -> '%s'\n", v.Name, v.Old, v.New)
+			}
+		}
+
+		printFilesDiff(stdout, "template", result.TemplateFiles)
+		printFilesDiff(stdout, "file", result.FoundFiles)
+		printFilesDiff(stdout, "generated file", result.GeneratedFiles)
+	}
+
+	if !result.Empty() {
+		return exitFailed
+	}
+
+	return exitOk
+}
+
+func printFilesDiff(w io.Writer, kind string, diff breadcrumbs.FilesDiff) {
+	for _, m := range diff.Added {
+		fmt.Fprintf(w, "%s added: %s (sha256 %s)\n", kind, m.FoundAtPath, m.Sha256)
+	}
+
+	for _, m := range diff.Removed {
+		fmt.Fprintf(w, "%s removed: %s (sha256 %s)\n", kind, m.FoundAtPath, m.Sha256)
+	}
+
+	for _, c := range diff.Changed {
+		fmt.Fprintf(w, "%s changed: %s - sha256 %s -> %s\n", kind, c.New.FoundAtPath, c.Old.Sha256, c.New.Sha256)
+		if len(c.UnifiedDiff) > 0 {
+			io.WriteString(w, c.UnifiedDiff)
+		}
+	}
+}
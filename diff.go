@@ -0,0 +1,331 @@
+package breadcrumbs
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// ChangeKind describes how a value differs between two manifests.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// ManifestDiff describes the differences between two manifests.
+type ManifestDiff struct {
+	// Fields contains the changed top-level manifest fields (e.g.,
+	// 'git_revision'). The change's Name is the field's JSON name.
+	Fields []ValueChange `json:"fields"`
+
+	// UserVars contains the added, removed, and changed packer
+	// user variables.
+	UserVars []ValueChange `json:"packer_user_variables"`
+
+	// TemplateFiles contains the differences between the stored
+	// packer templates, which are matched by file name.
+	TemplateFiles FilesDiff `json:"packer_template_files"`
+
+	// FoundFiles contains the differences between the files that
+	// were found in the templates, which are matched by the path
+	// they were found at.
+	FoundFiles FilesDiff `json:"found_files"`
+
+	// GeneratedFiles contains the differences between the files
+	// generated by the plugin (e.g., the package list), which are
+	// matched by file name.
+	GeneratedFiles FilesDiff `json:"generated_files"`
+}
+
+// Empty returns true if the manifests are the same.
+func (o ManifestDiff) Empty() bool {
+	return len(o.Fields) == 0 && len(o.UserVars) == 0 &&
+		o.TemplateFiles.Empty() && o.FoundFiles.Empty() && o.GeneratedFiles.Empty()
+}
+
+// ValueChange is a string value that differs between two manifests.
+type ValueChange struct {
+	Name string     `json:"name"`
+	Kind ChangeKind `json:"kind"`
+	Old  string     `json:"old"`
+	New  string     `json:"new"`
+}
+
+// FilesDiff describes the differences between two lists of files.
+type FilesDiff struct {
+	Added   []FileMeta    `json:"added"`
+	Removed []FileMeta    `json:"removed"`
+	Changed []ChangedFile `json:"changed"`
+}
+
+// Empty returns true if no files were added, removed, or changed.
+func (o FilesDiff) Empty() bool {
+	return len(o.Added) == 0 && len(o.Removed) == 0 && len(o.Changed) == 0
+}
+
+// ChangedFile is a file whose content hash differs between
+// two manifests.
+type ChangedFile struct {
+	Old FileMeta `json:"old"`
+	New FileMeta `json:"new"`
+
+	// UnifiedDiff is a unified diff of the file's stored contents.
+	// It is only set by Diff and DiffDirs, and only if both versions
+	// of the file are text and no larger than 1 MiB.
+	UnifiedDiff string `json:"unified_diff,omitempty"`
+}
+
+// DiffManifests compares two manifests. Files are considered changed
+// if their SHA-256 hashes differ. Files without a recorded hash (i.e.,
+// files recorded by older versions of the plugin) are never reported
+// as changed.
+func DiffManifests(a *Manifest, b *Manifest) *ManifestDiff {
+	diff := &ManifestDiff{}
+
+	aGit := gitInfoOrEmpty(a)
+	bGit := gitInfoOrEmpty(b)
+
+	fields := []struct {
+		name string
+		a    string
+		b    string
+	}{
+		{name: "plugin_version", a: a.PluginVersion, b: b.PluginVersion},
+		{name: "vcs", a: a.VCS, b: b.VCS},
+		{name: "git_revision", a: a.GitRevision, b: b.GitRevision},
+		{name: "git_branch", a: aGit.Branch, b: bGit.Branch},
+		{name: "git_remote", a: aGit.Remote, b: bGit.Remote},
+		{name: "git_dirty", a: strconv.FormatBool(aGit.Dirty), b: strconv.FormatBool(bGit.Dirty)},
+		{name: "tree_hash", a: a.TreeHash, b: b.TreeHash},
+		{name: "packer_build_name", a: a.PackerBuildName, b: b.PackerBuildName},
+		{name: "packer_build_type", a: a.PackerBuildType, b: b.PackerBuildType},
+		{name: "os_name", a: a.OSName, b: b.OSName},
+		{name: "os_version", a: a.OSVersion, b: b.OSVersion},
+		{name: "kernel_release", a: a.KernelRelease, b: b.KernelRelease},
+		{name: "architecture", a: a.Architecture, b: b.Architecture},
+		{name: "virtualization", a: a.Virtualization, b: b.Virtualization},
+		{name: "boot_mode", a: a.BootMode, b: b.BootMode},
+		{name: "init_system", a: a.InitSystem, b: b.InitSystem},
+		{name: "packer_template_format", a: string(a.TemplateFormat), b: string(b.TemplateFormat)},
+	}
+
+	for _, f := range fields {
+		if f.a != f.b {
+			diff.Fields = append(diff.Fields, ValueChange{
+				Name: f.name,
+				Kind: Changed,
+				Old:  f.a,
+				New:  f.b,
+			})
+		}
+	}
+
+	diff.UserVars = diffStringMaps(a.PackerUserVars, b.PackerUserVars)
+
+	diff.TemplateFiles = diffFileMetas(a.TemplateFiles, b.TemplateFiles, func(m FileMeta) string {
+		return m.Name
+	})
+
+	diff.FoundFiles = diffFileMetas(a.FoundFiles, b.FoundFiles, func(m FileMeta) string {
+		return m.FoundAtPath
+	})
+
+	diff.GeneratedFiles = diffFileMetas(a.GeneratedFiles, b.GeneratedFiles, func(m FileMeta) string {
+		return m.Name
+	})
+
+	return diff
+}
+
+// DiffDirs compares the breadcrumbs stored in two directories (or
+// archives of them). Unlike DiffManifests, a unified diff is generated
+// for each changed file whose stored contents are text.
+func DiffDirs(aPath string, bPath string) (*ManifestDiff, error) {
+	a, err := Open(aPath)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+
+	b, err := Open(bPath)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	return Diff(a, b)
+}
+
+// Diff compares two opened breadcrumbs. Like DiffDirs, a unified diff
+// is generated for each changed file whose stored contents are text.
+func Diff(a *Breadcrumbs, b *Breadcrumbs) (*ManifestDiff, error) {
+	diff := DiffManifests(a.Manifest, b.Manifest)
+
+	for _, changed := range [][]ChangedFile{diff.TemplateFiles.Changed, diff.FoundFiles.Changed, diff.GeneratedFiles.Changed} {
+		for i := range changed {
+			// Large files are skipped because they are unlikely
+			// to produce a readable diff, and diffing them can
+			// use a lot of memory.
+			if changed[i].Old.SizeBytes > maxUnifiedDiffSizeBytes || changed[i].New.SizeBytes > maxUnifiedDiffSizeBytes {
+				continue
+			}
+
+			aRaw, err := readStoredFileUpTo(a, changed[i].Old, maxUnifiedDiffSizeBytes+1)
+			if err != nil {
+				return nil, err
+			}
+
+			bRaw, err := readStoredFileUpTo(b, changed[i].New, maxUnifiedDiffSizeBytes+1)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(aRaw) > maxUnifiedDiffSizeBytes || len(bRaw) > maxUnifiedDiffSizeBytes ||
+				!isText(aRaw) || !isText(bRaw) {
+				continue
+			}
+
+			changed[i].UnifiedDiff = unifiedDiff("a/"+changed[i].Old.Name, "b/"+changed[i].New.Name, aRaw, bRaw)
+		}
+	}
+
+	return diff, nil
+}
+
+// gitInfoOrEmpty returns a manifest's git information, or an empty
+// GitInfo for manifests created before it was recorded.
+func gitInfoOrEmpty(m *Manifest) GitInfo {
+	if m.Git == nil {
+		return GitInfo{}
+	}
+
+	return *m.Git
+}
+
+func readStoredFile(b *Breadcrumbs, meta FileMeta) ([]byte, error) {
+	r, err := b.OpenFile(meta)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// readStoredFileUpTo is like readStoredFile, but reads at most
+// maxBytes of the file.
+func readStoredFileUpTo(b *Breadcrumbs, meta FileMeta, maxBytes int64) ([]byte, error) {
+	r, err := b.OpenFile(meta)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(io.LimitReader(r, maxBytes))
+}
+
+func diffStringMaps(a map[string]string, b map[string]string) []ValueChange {
+	var changes []ValueChange
+
+	for name, aValue := range a {
+		bValue, ok := b[name]
+		if !ok {
+			changes = append(changes, ValueChange{
+				Name: name,
+				Kind: Removed,
+				Old:  aValue,
+			})
+			continue
+		}
+
+		if aValue != bValue {
+			changes = append(changes, ValueChange{
+				Name: name,
+				Kind: Changed,
+				Old:  aValue,
+				New:  bValue,
+			})
+		}
+	}
+
+	for name, bValue := range b {
+		if _, ok := a[name]; !ok {
+			changes = append(changes, ValueChange{
+				Name: name,
+				Kind: Added,
+				New:  bValue,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+func diffFileMetas(a []FileMeta, b []FileMeta, keyFn func(FileMeta) string) FilesDiff {
+	var diff FilesDiff
+
+	// The same file can be recorded more than once (e.g., if it is
+	// referenced by two provisioners), so each file is only compared
+	// once.
+	a = uniqueFileMetas(a, keyFn)
+	b = uniqueFileMetas(b, keyFn)
+
+	bMetas := make(map[string]FileMeta, len(b))
+	for _, meta := range b {
+		bMetas[keyFn(meta)] = meta
+	}
+
+	aKeys := make(map[string]bool, len(a))
+
+	for _, aMeta := range a {
+		key := keyFn(aMeta)
+		aKeys[key] = true
+
+		bMeta, ok := bMetas[key]
+		if !ok {
+			diff.Removed = append(diff.Removed, aMeta)
+			continue
+		}
+
+		if len(aMeta.Sha256) > 0 && len(bMeta.Sha256) > 0 && aMeta.Sha256 != bMeta.Sha256 {
+			diff.Changed = append(diff.Changed, ChangedFile{
+				Old: aMeta,
+				New: bMeta,
+			})
+		}
+	}
+
+	for _, bMeta := range b {
+		if !aKeys[keyFn(bMeta)] {
+			diff.Added = append(diff.Added, bMeta)
+		}
+	}
+
+	return diff
+}
+
+// uniqueFileMetas returns the first FileMeta for each key.
+func uniqueFileMetas(metas []FileMeta, keyFn func(FileMeta) string) []FileMeta {
+	var unique []FileMeta
+	seen := make(map[string]bool, len(metas))
+
+	for _, meta := range metas {
+		key := keyFn(meta)
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		unique = append(unique, meta)
+	}
+
+	return unique
+}
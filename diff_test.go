@@ -0,0 +1,128 @@
+package breadcrumbs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestDiffManifests(t *testing.T) {
+	a := &Manifest{
+		GitRevision: "aaa",
+		OSName:      "debian",
+		PackerUserVars: map[string]string{
+			"removed": "x",
+			"changed": "1",
+			"same":    "y",
+		},
+		FoundFiles: []FileMeta{
+			{Name: "a.sh", FoundAtPath: "/a.sh", Sha256: "1"},
+			{Name: "b.sh", FoundAtPath: "/b.sh", Sha256: "2"},
+			{Name: "c.sh", FoundAtPath: "/c.sh", Sha256: "3"},
+			// Files referenced more than once are recorded
+			// more than once.
+			{Name: "b.sh", FoundAtPath: "/b.sh", Sha256: "2"},
+			{Name: "c.sh", FoundAtPath: "/c.sh", Sha256: "3"},
+		},
+	}
+
+	b := &Manifest{
+		GitRevision: "bbb",
+		OSName:      "debian",
+		PackerUserVars: map[string]string{
+			"added":   "z",
+			"changed": "2",
+			"same":    "y",
+		},
+		FoundFiles: []FileMeta{
+			{Name: "a.sh", FoundAtPath: "/a.sh", Sha256: "1"},
+			{Name: "b.sh", FoundAtPath: "/b.sh", Sha256: "22"},
+			{Name: "d.sh", FoundAtPath: "/d.sh", Sha256: "4"},
+			{Name: "b.sh", FoundAtPath: "/b.sh", Sha256: "22"},
+			{Name: "d.sh", FoundAtPath: "/d.sh", Sha256: "4"},
+		},
+	}
+
+	diff := DiffManifests(a, b)
+
+	if len(diff.Fields) != 1 || diff.Fields[0].Name != "git_revision" ||
+		diff.Fields[0].Old != "aaa" || diff.Fields[0].New != "bbb" {
+		t.Fatalf("expected only git_revision to change - got %+v", diff.Fields)
+	}
+
+	expectedVars := []ValueChange{
+		{Name: "added", Kind: Added, New: "z"},
+		{Name: "changed", Kind: Changed, Old: "1", New: "2"},
+		{Name: "removed", Kind: Removed, Old: "x"},
+	}
+	if len(diff.UserVars) != len(expectedVars) {
+		t.Fatalf("expected %d user variable changes - got %+v", len(expectedVars), diff.UserVars)
+	}
+	for i := range expectedVars {
+		if diff.UserVars[i] != expectedVars[i] {
+			t.Fatalf("expected user variable change %+v - got %+v", expectedVars[i], diff.UserVars[i])
+		}
+	}
+
+	if len(diff.FoundFiles.Added) != 1 || diff.FoundFiles.Added[0].Name != "d.sh" {
+		t.Fatalf("expected d.sh to be added - got %+v", diff.FoundFiles.Added)
+	}
+
+	if len(diff.FoundFiles.Removed) != 1 || diff.FoundFiles.Removed[0].Name != "c.sh" {
+		t.Fatalf("expected c.sh to be removed - got %+v", diff.FoundFiles.Removed)
+	}
+
+	if len(diff.FoundFiles.Changed) != 1 || diff.FoundFiles.Changed[0].New.Name != "b.sh" {
+		t.Fatalf("expected b.sh to be changed - got %+v", diff.FoundFiles.Changed)
+	}
+
+	if diff.Empty() {
+		t.Fatal("diff should not be empty")
+	}
+
+	if !DiffManifests(a, a).Empty() {
+		t.Fatal("diff of a manifest with itself should be empty")
+	}
+}
+
+func TestDiffDirs(t *testing.T) {
+	aDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(aDirPath))
+
+	err := ioutil.WriteFile(manifest.FoundFiles[0].FoundAtPath, []byte("echo changed\n"), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	bDirPath := filepath.Join(filepath.Dir(aDirPath), "breadcrumbs-b")
+
+	err = createBreadcrumbs(context.Background(), packer.TestUi(t), bDirPath, manifest, &PluginConfig{
+		SaveFileSizeBytes: defaultSaveFileSizeBytes,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	diff, err := DiffDirs(aDirPath, bDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(diff.FoundFiles.Changed) != 1 {
+		t.Fatalf("expected one changed file - got %+v", diff.FoundFiles)
+	}
+
+	changed := diff.FoundFiles.Changed[0]
+	if !strings.Contains(changed.UnifiedDiff, "-echo a.sh\n+echo changed\n") {
+		t.Fatalf("unexpected unified diff:\n%s", changed.UnifiedDiff)
+	}
+
+	if len(diff.Fields) != 0 || !diff.TemplateFiles.Empty() {
+		t.Fatalf("only a.sh should have changed - got %+v", diff)
+	}
+}
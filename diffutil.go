@@ -0,0 +1,247 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	unifiedDiffContextLines = 3
+
+	// maxUnifiedDiffSizeBytes is the maximum size of a file that a
+	// unified diff is generated for.
+	maxUnifiedDiffSizeBytes = 1024 * 1024
+)
+
+type diffOpKind byte
+
+const (
+	diffEqual  diffOpKind = ' '
+	diffDelete diffOpKind = '-'
+	diffInsert diffOpKind = '+'
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+// isText returns true if the data looks like text (i.e., it is valid
+// UTF-8 and does not contain any NUL bytes).
+func isText(raw []byte) bool {
+	return utf8.Valid(raw) && bytes.IndexByte(raw, 0) < 0
+}
+
+// unifiedDiff returns a unified diff of two byte slices, or an empty
+// string if they are the same.
+func unifiedDiff(aName string, bName string, a []byte, b []byte) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	hunks := bytes.NewBuffer(nil)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+
+		// Find the end of the hunk, which is the last change that
+		// is followed by fewer than twice the context lines.
+		start := i - unifiedDiffContextLines
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != diffEqual {
+				end = j
+				continue
+			}
+
+			if j-end > 2*unifiedDiffContextLines {
+				break
+			}
+		}
+
+		end = end + unifiedDiffContextLines + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(hunks, ops, start, end)
+
+		i = end
+	}
+
+	if hunks.Len() == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("--- %s\n+++ %s\n%s", aName, bName, hunks.String())
+}
+
+func writeHunk(w *bytes.Buffer, ops []diffOp, start int, end int) {
+	// Line numbers are 1-based, and are relative to
+	// the beginning of each file.
+	aLine, bLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != diffInsert {
+			aLine++
+		}
+		if op.kind != diffDelete {
+			bLine++
+		}
+	}
+
+	aCount, bCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != diffInsert {
+			aCount++
+		}
+		if op.kind != diffDelete {
+			bCount++
+		}
+	}
+
+	if aCount == 0 {
+		aLine--
+	}
+	if bCount == 0 {
+		bLine--
+	}
+
+	fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+
+	for _, op := range ops[start:end] {
+		w.WriteByte(byte(op.kind))
+		w.WriteString(op.line)
+		w.WriteByte('\n')
+	}
+}
+
+func splitLines(raw []byte) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script that transforms a into b
+// using the Myers diff algorithm.
+func diffLines(a []string, b []string) []diffOp {
+	// Lines shared at the start and end of both inputs
+	// are trimmed to reduce the work done by Myers.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{kind: diffEqual, line: line})
+	}
+
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{kind: diffEqual, line: line})
+	}
+
+	return ops
+}
+
+func myers(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+
+	// v holds the furthest x value reached for each diagonal k.
+	// The diagonals that step d can read (-d-1 to d+1) are saved
+	// before each step so that the path can be recovered afterwards.
+	// Only saving those diagonals keeps the trace's size proportional
+	// to the square of the number of edits rather than to the number
+	// of edits times the number of lines.
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+OUTER:
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break OUTER
+			}
+		}
+	}
+
+	var reversed []diffOp
+	x, y := n, m
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		// The snapshot for step d starts at diagonal -d-1.
+		v := trace[d]
+		vOffset := d + 1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[vOffset+k-1] < v[vOffset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[vOffset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffOp{kind: diffEqual, line: a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffOp{kind: diffInsert, line: b[y-1]})
+				y--
+			} else {
+				reversed = append(reversed, diffOp{kind: diffDelete, line: a[x-1]})
+				x--
+			}
+		}
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i := range reversed {
+		ops[len(reversed)-1-i] = reversed[i]
+	}
+
+	return ops
+}
@@ -0,0 +1,479 @@
+package breadcrumbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+const (
+	defaultMaxParallelFetches  = 4
+	defaultHttpAttempts        = 3
+	defaultHttpRetryBackoff    = time.Second
+	defaultHttpRetryMaxBackoff = 30 * time.Second
+	defaultHttpTimeout         = 30 * time.Second
+)
+
+// defaultHttpRetryStatusCodes are the HTTP status codes that are retried
+// if 'http_retry_status_codes' is not set.
+var defaultHttpRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// httpRetryableError is returned by getHttpFile when a request fails
+// in a way that may succeed if it is retried (e.g., the connection
+// was reset).
+type httpRetryableError struct {
+	err error
+}
+
+func (o *httpRetryableError) Error() string {
+	return o.err.Error()
+}
+
+// httpStatusError is returned by getHttpFile when the server responds
+// with a status code other than 200.
+type httpStatusError struct {
+	url        string
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (o *httpStatusError) Error() string {
+	return fmt.Sprintf("failed to GET http file '%s' - got status code %d", o.url, o.statusCode)
+}
+
+// parseRetryAfter parses the value of a 'Retry-After' header. It returns
+// zero if the value is empty or invalid.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+
+	wait := time.Until(date)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// httpRetryPolicy controls how HTTP files are downloaded and retried.
+type httpRetryPolicy struct {
+	attempts     int
+	statusCodes  map[int]bool
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	timeout      time.Duration
+	hostTimeouts map[string]time.Duration
+	deadline     time.Duration
+
+	randLock sync.Mutex
+	rand     *rand.Rand
+}
+
+func newHttpRetryPolicy(config *PluginConfig) (*httpRetryPolicy, error) {
+	policy := &httpRetryPolicy{
+		attempts:     config.HttpAttempts,
+		statusCodes:  make(map[int]bool),
+		backoff:      defaultHttpRetryBackoff,
+		maxBackoff:   defaultHttpRetryMaxBackoff,
+		timeout:      defaultHttpTimeout,
+		hostTimeouts: make(map[string]time.Duration),
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if policy.attempts < 0 {
+		return nil, fmt.Errorf("http_attempts cannot be negative")
+	} else if policy.attempts == 0 {
+		policy.attempts = defaultHttpAttempts
+	}
+
+	statusCodes := config.HttpRetryStatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultHttpRetryStatusCodes
+	}
+
+	for _, code := range statusCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("http retry status code %d is not a valid http status code", code)
+		}
+
+		policy.statusCodes[code] = true
+	}
+
+	durations := []struct {
+		name   string
+		value  string
+		result *time.Duration
+	}{
+		{name: "http_retry_backoff", value: config.HttpRetryBackoff, result: &policy.backoff},
+		{name: "http_retry_max_backoff", value: config.HttpRetryMaxBackoff, result: &policy.maxBackoff},
+		{name: "http_timeout", value: config.HttpTimeout, result: &policy.timeout},
+		{name: "http_deadline", value: config.HttpDeadline, result: &policy.deadline},
+	}
+
+	for _, d := range durations {
+		if len(d.value) == 0 {
+			continue
+		}
+
+		duration, err := parseConfigDuration(d.name, d.value)
+		if err != nil {
+			return nil, err
+		}
+
+		*d.result = duration
+	}
+
+	if policy.maxBackoff < policy.backoff {
+		return nil, fmt.Errorf("http_retry_max_backoff cannot be less than http_retry_backoff")
+	}
+
+	for host, value := range config.HttpHostTimeouts {
+		duration, err := parseConfigDuration("http_host_timeouts."+host, value)
+		if err != nil {
+			return nil, err
+		}
+
+		policy.hostTimeouts[strings.ToLower(host)] = duration
+	}
+
+	return policy, nil
+}
+
+// parseConfigDuration parses a duration string (e.g., '1m30s').
+// Zero means no timeout.
+func parseConfigDuration(name string, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s '%s' - %s", name, value, err.Error())
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", name)
+	}
+
+	return duration, nil
+}
+
+// timeoutFor returns the per-request timeout for a URL. Host timeouts
+// can be specified with or without the port.
+func (o *httpRetryPolicy) timeoutFor(u *url.URL) time.Duration {
+	if timeout, ok := o.hostTimeouts[strings.ToLower(u.Host)]; ok {
+		return timeout
+	}
+
+	if timeout, ok := o.hostTimeouts[strings.ToLower(u.Hostname())]; ok {
+		return timeout
+	}
+
+	return o.timeout
+}
+
+// retryDelay returns how long to wait before retrying a request, or
+// false if the error cannot be retried. The delay doubles after each
+// attempt (up to the maximum backoff), and is randomized between half
+// and all of that value so that parallel fetches do not retry in step.
+// A server's 'Retry-After' header is honored up to the maximum backoff.
+func (o *httpRetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	var retryAfter time.Duration
+
+	switch e := err.(type) {
+	case *httpRetryableError:
+	case *httpStatusError:
+		if !o.statusCodes[e.statusCode] {
+			return 0, false
+		}
+		retryAfter = e.retryAfter
+	default:
+		return 0, false
+	}
+
+	delay := o.backoff
+	for i := 1; i < attempt && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.maxBackoff {
+		delay = o.maxBackoff
+	}
+
+	if delay > 1 {
+		o.randLock.Lock()
+		delay = delay/2 + time.Duration(o.rand.Int63n(int64(delay/2)+1))
+		o.randLock.Unlock()
+	}
+
+	if retryAfter > delay {
+		delay = retryAfter
+		if delay > o.maxBackoff {
+			delay = o.maxBackoff
+		}
+	}
+
+	return delay, true
+}
+
+// getHttpFile downloads an HTTP file, retrying failed attempts according
+// to the policy. Each retry is reported to the ui, which refers to the
+// file as displayUrl so that secrets in the URL are not shown.
+func (o *httpRetryPolicy) getHttpFile(ctx context.Context, ui packer.Ui, transport http.RoundTripper, p *url.URL, displayUrl string, destPath string, maxSizeBytes int64, withSha512 bool) (savedFile, error) {
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   o.timeoutFor(p),
+	}
+
+	for attempt := 1; ; attempt++ {
+		saved, err := getHttpFile(ctx, httpClient, p, destPath, 0600, maxSizeBytes, withSha512)
+		if err == nil {
+			return saved, nil
+		}
+
+		if ctx.Err() != nil {
+			return savedFile{}, fmt.Errorf("%s (attempt %d of %d) - %s",
+				err.Error(), attempt, o.attempts, ctx.Err().Error())
+		}
+
+		delay, retryable := o.retryDelay(attempt, err)
+		if !retryable {
+			return savedFile{}, err
+		}
+
+		if attempt >= o.attempts {
+			return savedFile{}, fmt.Errorf("%s - gave up after %d attempt(s)", err.Error(), attempt)
+		}
+
+		ui.Message(fmt.Sprintf("Attempt %d of %d to get '%s' failed - %s - retrying in %s...",
+			attempt, o.attempts, displayUrl, strings.Replace(err.Error(), p.String(), displayUrl, -1),
+			delay.Round(time.Millisecond)))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return savedFile{}, fmt.Errorf("%s (attempt %d of %d) - %s",
+				err.Error(), attempt, o.attempts, ctx.Err().Error())
+		}
+	}
+}
+
+// fetchFoundFiles saves the manifest's found files to the breadcrumbs
+// directory using up to 'max_parallel_fetches' workers. Each file's
+// metadata is stored at its index in the manifest, so the manifest's
+// order does not depend on the order in which the fetches complete.
+//
+// Every file is fetched even if another fails. The returned error lists
+// each failed file in manifest order.
+func fetchFoundFiles(ctx context.Context, ui packer.Ui, rootDirPath string, manifest *Manifest, config *PluginConfig) error {
+	policy, err := newHttpRetryPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	transport, err := newHttpTransport(config)
+	if err != nil {
+		return err
+	}
+
+	mirrors, err := newMirrorMap(config)
+	if err != nil {
+		return err
+	}
+
+	fetcher := &fileFetcher{
+		ui:        ui,
+		policy:    policy,
+		transport: transport,
+		mirrors:   mirrors,
+		secrets:   manifest.secrets,
+		config:    config,
+	}
+
+	// The same file can be referenced more than once. Each
+	// destination is only fetched once so that workers do
+	// not write to the same file.
+	var jobs []int
+	firstIndex := make(map[string]int)
+	duplicateOf := make(map[int]int)
+
+	for i, meta := range manifest.FoundFiles {
+		if first, ok := firstIndex[meta.StoredAtPath]; ok {
+			duplicateOf[i] = first
+			continue
+		}
+
+		firstIndex[meta.StoredAtPath] = i
+		jobs = append(jobs, i)
+	}
+
+	numWorkers := config.MaxParallelFetches
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if numWorkers > len(jobs) {
+		numWorkers = len(jobs)
+	}
+
+	errs := make([]error, len(manifest.FoundFiles))
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for n := 0; n < numWorkers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				err := fetcher.fetch(ctx, rootDirPath, &manifest.FoundFiles[i])
+				if err != nil {
+					// Errors can contain the unredacted URL.
+					errs[i] = errors.New(redactText(err.Error(), fetcher.secrets))
+				}
+			}
+		}()
+	}
+
+	for _, i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	for i, first := range duplicateOf {
+		manifest.FoundFiles[i].ResolvedPath = manifest.FoundFiles[first].ResolvedPath
+		manifest.FoundFiles[i].SizeBytes = manifest.FoundFiles[first].SizeBytes
+		manifest.FoundFiles[i].Sha1 = manifest.FoundFiles[first].Sha1
+		manifest.FoundFiles[i].Sha256 = manifest.FoundFiles[first].Sha256
+		manifest.FoundFiles[i].Sha512 = manifest.FoundFiles[first].Sha512
+		manifest.FoundFiles[i].ModTime = manifest.FoundFiles[first].ModTime
+		manifest.FoundFiles[i].HttpLastModified = manifest.FoundFiles[first].HttpLastModified
+		manifest.FoundFiles[i].HttpETag = manifest.FoundFiles[first].HttpETag
+	}
+
+	var result *packer.MultiError
+
+	for _, err := range errs {
+		if err != nil {
+			result = packer.MultiErrorAppend(result, err)
+		}
+	}
+
+	if result != nil {
+		return fmt.Errorf("failed to save %d of %d found file(s) - %s",
+			len(result.Errors), len(jobs), result.Error())
+	}
+
+	return nil
+}
+
+// fileFetcher saves found files to the breadcrumbs directory.
+type fileFetcher struct {
+	ui        packer.Ui
+	policy    *httpRetryPolicy
+	transport http.RoundTripper
+	mirrors   *mirrorMap
+	secrets   map[string]string
+	config    *PluginConfig
+}
+
+func (o *fileFetcher) fetch(ctx context.Context, rootDirPath string, meta *FileMeta) error {
+	destDirPath := meta.DestinationDirPath(rootDirPath)
+	err := os.MkdirAll(destDirPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	destPath := path.Join(destDirPath, meta.StoredAtPath)
+
+	meta.ResolvedPath = ""
+
+	var saved savedFile
+
+	location := meta.location()
+
+	switch meta.Source {
+	case HttpHost, HttpsHost:
+		resolved, isLocal, err := o.mirrors.resolve(location)
+		if err != nil {
+			return err
+		}
+
+		if resolved != location {
+			meta.ResolvedPath = redactText(resolved, o.secrets)
+		}
+
+		if isLocal {
+			saved, err = copyLocalFile(ctx, resolved, destPath, 0600, o.config.SaveFileSizeBytes, o.config.HashSha512)
+			if err != nil {
+				return fmt.Errorf("failed to copy mirrored file '%s' from '%s' to '%s' - %s",
+					meta.FoundAtPath, meta.ResolvedPath, destPath, err.Error())
+			}
+			break
+		}
+
+		p, err := url.Parse(resolved)
+		if err != nil {
+			return err
+		}
+
+		saved, err = o.policy.getHttpFile(ctx, o.ui, o.transport, p, redactText(resolved, o.secrets), destPath,
+			o.config.SaveFileSizeBytes, o.config.HashSha512)
+		if err != nil {
+			if len(meta.ResolvedPath) > 0 {
+				return fmt.Errorf("failed to get http file '%s' from mirror '%s' - %s",
+					meta.FoundAtPath, meta.ResolvedPath, err.Error())
+			}
+			return fmt.Errorf("failed to get http file '%s' - %s", meta.FoundAtPath, err.Error())
+		}
+	case LocalStorage:
+		saved, err = copyLocalFile(ctx, location, destPath, 0600, o.config.SaveFileSizeBytes, o.config.HashSha512)
+		if err != nil {
+			return fmt.Errorf("failed to copy local file '%s' to '%s' - %s",
+				meta.FoundAtPath, destPath, err.Error())
+		}
+	default:
+		return fmt.Errorf("unknown file source '%s' for '%s'", meta.Source, meta.FoundAtPath)
+	}
+
+	meta.setSaved(saved)
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// GitDiffFileName is the name of the working tree diff stored
+	// in the breadcrumbs directory when 'save_git_diff' is enabled
+	// and the working tree has uncommitted changes.
+	GitDiffFileName = "git.diff"
+)
+
+// GitInfo describes the state of the git repository containing the
+// packer template.
+type GitInfo struct {
+	// Branch is the current branch. It is empty if HEAD is detached.
+	Branch string `json:"branch"`
+
+	// Tags are the sorted tags that point at HEAD.
+	Tags []string `json:"tags"`
+
+	// Remote is the URL of the 'origin' remote (or the first remote
+	// if there is no 'origin') with any credentials removed. It is
+	// empty if the repository has no remotes.
+	Remote string `json:"remote"`
+
+	// Author is the HEAD commit's author (e.g., 'Name <email>'),
+	// and AuthorDate is the date it was authored (RFC 3339).
+	Author     string `json:"author"`
+	AuthorDate string `json:"author_date"`
+
+	// Dirty is true if the working tree has uncommitted changes,
+	// meaning that GitRevision does not fully describe the files
+	// that were used to build the image.
+	Dirty bool `json:"dirty"`
+
+	// ModifiedFiles and UntrackedFiles are the sorted paths (relative
+	// to the repository root) of the files that have uncommitted
+	// changes, and of the files that are not tracked by git. Ignored
+	// files are not included.
+	ModifiedFiles  []string `json:"modified_files"`
+	UntrackedFiles []string `json:"untracked_files"`
+
+	// DiffFile is the path to the working tree diff (relative to the
+	// manifest file). It is omitted unless 'save_git_diff' is enabled
+	// and the working tree is dirty.
+	DiffFile string `json:"diff_file,omitempty"`
+}
+
+func gitOutput(dirPath string, env []string, args ...string) ([]byte, error) {
+	return gitOutputUpTo(dirPath, env, math.MaxInt64-1, args...)
+}
+
+// gitOutputUpTo is like gitOutput, but stops git and returns
+// errExceedsMaxSize if it writes more than maxSizeBytes.
+func gitOutputUpTo(dirPath string, env []string, maxSizeBytes int64, args ...string) ([]byte, error) {
+	git := exec.Command("git", args...)
+	git.Dir = dirPath
+	if env != nil {
+		git.Env = append(os.Environ(), env...)
+	}
+
+	stderr := bytes.NewBuffer(nil)
+	git.Stderr = stderr
+
+	stdout, err := git.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = git.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git %s - %s", strings.Join(args, " "), err.Error())
+	}
+
+	// Read one byte past the maximum size so that output
+	// which exceeds the maximum can be detected.
+	raw, readErr := ioutil.ReadAll(io.LimitReader(stdout, maxSizeBytes+1))
+	if readErr == nil && int64(len(raw)) > maxSizeBytes {
+		git.Process.Kill()
+		git.Wait()
+		return nil, errExceedsMaxSize
+	}
+
+	err = git.Wait()
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git %s - %s - stderr: '%s'",
+			strings.Join(args, " "), err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return raw, nil
+}
+
+func currentGitInfo(projectDirPath string) (*GitInfo, error) {
+	info := &GitInfo{
+		Tags:           []string{},
+		ModifiedFiles:  []string{},
+		UntrackedFiles: []string{},
+	}
+
+	// 'symbolic-ref' fails when HEAD is detached.
+	raw, err := gitOutput(projectDirPath, nil, "symbolic-ref", "--short", "-q", "HEAD")
+	if err == nil {
+		info.Branch = string(bytes.TrimSpace(raw))
+	}
+
+	raw, err = gitOutput(projectDirPath, nil, "tag", "--points-at", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	info.Tags = append(info.Tags, strings.Fields(string(raw))...)
+	sort.Strings(info.Tags)
+
+	info.Remote = currentGitRemote(projectDirPath)
+
+	raw, err = gitOutput(projectDirPath, nil, "log", "-1", "--format=%an <%ae>%x00%aI", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	authorParts := strings.SplitN(string(bytes.TrimSpace(raw)), "\x00", 2)
+	info.Author = authorParts[0]
+	if len(authorParts) > 1 {
+		info.AuthorDate = authorParts[1]
+	}
+
+	raw, err = gitOutput(projectDirPath, nil, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	modified, untracked := parseGitStatus(raw)
+	info.ModifiedFiles = append(info.ModifiedFiles, modified...)
+	info.UntrackedFiles = append(info.UntrackedFiles, untracked...)
+	info.Dirty = len(modified) > 0 || len(untracked) > 0
+
+	return info, nil
+}
+
+// parseGitStatus parses the output of 'git status --porcelain -z',
+// and returns the sorted paths of modified and untracked files.
+func parseGitStatus(raw []byte) ([]string, []string) {
+	var modified []string
+	var untracked []string
+
+	entries := strings.Split(string(raw), "\x00")
+
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+
+		status := entry[:2]
+		filePath := entry[3:]
+
+		switch {
+		case status == "??":
+			untracked = append(untracked, filePath)
+		case status == "!!":
+		default:
+			modified = append(modified, filePath)
+
+			// Renames and copies are followed by the
+			// original path, which is skipped.
+			if strings.ContainsAny(status, "RC") {
+				i++
+			}
+		}
+	}
+
+	sort.Strings(modified)
+	sort.Strings(untracked)
+
+	return modified, untracked
+}
+
+// gitWorkingTreeDiff returns a binary diff between HEAD and the working
+// tree, including untracked files. Applying it to a clone of the
+// repository at HEAD (using 'git apply') reconstructs the working tree.
+//
+// Untracked files are only included in a diff once they are staged, so
+// they are staged in a temporary index rather than the repository's.
+// Staging a file writes it to the object database, so the objects are
+// written to a temporary object directory as well. That way, untracked
+// files (which can be large, or contain secrets) are not copied into
+// the repository.
+//
+// errExceedsMaxSize is returned if the diff is larger than maxSizeBytes.
+// Untracked files larger than maxSizeBytes cause an error before they
+// are staged.
+func gitWorkingTreeDiff(projectDirPath string, maxSizeBytes int64) ([]byte, error) {
+	tempDirPath, err := ioutil.TempDir("", "breadcrumbs-git-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDirPath)
+
+	// git does not recognize the repository if the object
+	// directory does not exist.
+	err = os.Mkdir(filepath.Join(tempDirPath, "objects"), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	rootDir, err := gitOutput(projectDirPath, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+
+	rootDirPath := string(bytes.TrimSpace(rootDir))
+
+	objectsDir, err := gitOutput(rootDirPath, nil, "rev-parse", "--git-path", "objects")
+	if err != nil {
+		return nil, err
+	}
+
+	objectsDirPath := string(bytes.TrimSpace(objectsDir))
+	if !filepath.IsAbs(objectsDirPath) {
+		objectsDirPath = filepath.Join(rootDirPath, objectsDirPath)
+	}
+
+	env := []string{
+		"GIT_INDEX_FILE=" + filepath.Join(tempDirPath, "index"),
+		"GIT_OBJECT_DIRECTORY=" + filepath.Join(tempDirPath, "objects"),
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + objectsDirPath,
+	}
+
+	untracked, err := gitOutput(rootDirPath, nil, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(string(untracked), "\x00") {
+		if len(name) == 0 {
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(rootDirPath, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Mode().IsRegular() && info.Size() > maxSizeBytes {
+			return nil, fmt.Errorf("untracked file '%s' is %d bytes, which exceeds the maximum of %d bytes",
+				name, info.Size(), maxSizeBytes)
+		}
+	}
+
+	_, err = gitOutput(rootDirPath, env, "read-tree", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = gitOutput(rootDirPath, env, "add", "-A")
+	if err != nil {
+		return nil, err
+	}
+
+	return gitOutputUpTo(rootDirPath, env, maxSizeBytes, "diff", "--cached", "--binary", "HEAD")
+}
+
+// currentGitRemote returns the URL of the 'origin' remote (or the first
+// remote if there is no 'origin') with any credentials removed, or an
+// empty string if there are no remotes.
+func currentGitRemote(projectDirPath string) string {
+	raw, err := gitOutput(projectDirPath, nil, "remote")
+	if err != nil {
+		return ""
+	}
+
+	remotes := strings.Fields(string(raw))
+	if len(remotes) == 0 {
+		return ""
+	}
+
+	name := remotes[0]
+	for _, remote := range remotes {
+		if remote == "origin" {
+			name = remote
+			break
+		}
+	}
+
+	raw, err = gitOutput(projectDirPath, nil, "config", "--get", "remote."+name+".url")
+	if err != nil {
+		return ""
+	}
+
+	return stripUrlCredentials(string(bytes.TrimSpace(raw)))
+}
+
+// stripUrlCredentials removes the user info from a URL. Strings that
+// are not URLs (such as scp-like git remotes) are returned unmodified.
+func stripUrlCredentials(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || len(u.Scheme) == 0 || u.User == nil {
+		return s
+	}
+
+	u.User = nil
+
+	return u.String()
+}
@@ -5,4 +5,7 @@ go 1.13
 require (
 	github.com/hashicorp/hcl/v2 v2.3.0
 	github.com/hashicorp/packer v1.5.6
+	github.com/zclconf/go-cty v1.3.2-0.20200309235747-0b5d9cf50df7
+	golang.org/x/crypto v0.0.0-20200117160349-530e935923ad
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 )
@@ -0,0 +1,72 @@
+package breadcrumbs
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+var (
+	errExceedsMaxSize = errors.New("file exceeds maximum size")
+)
+
+// savedFile describes a file that was saved as a breadcrumb.
+type savedFile struct {
+	sizeBytes    int64
+	sha1         string
+	sha256       string
+	sha512       string
+	modTime      time.Time
+	lastModified string
+	etag         string
+}
+
+// saveHashed copies r to dest, hashing the bytes as they are written.
+// It returns errExceedsMaxSize if r contains more than maxSizeBytes.
+func saveHashed(dest io.Writer, r io.Reader, maxSizeBytes int64, withSha512 bool) (savedFile, error) {
+	// SHA1 is only recorded because SPDX requires it for every file.
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	writers := []io.Writer{dest, sha1Hash, sha256Hash}
+
+	var sha512Hash hash.Hash
+	if withSha512 {
+		sha512Hash = sha512.New()
+		writers = append(writers, sha512Hash)
+	}
+
+	// Read one byte past the maximum size so that files which
+	// exceed the maximum can be detected.
+	n, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(r, maxSizeBytes+1))
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	if n > maxSizeBytes {
+		return savedFile{}, errExceedsMaxSize
+	}
+
+	saved := savedFile{
+		sizeBytes: n,
+		sha1:      fmt.Sprintf("%x", sha1Hash.Sum(nil)),
+		sha256:    fmt.Sprintf("%x", sha256Hash.Sum(nil)),
+	}
+
+	if sha512Hash != nil {
+		saved.sha512 = fmt.Sprintf("%x", sha512Hash.Sum(nil))
+	}
+
+	return saved, nil
+}
+
+// hashReader hashes the data read from r.
+func hashReader(r io.Reader, withSha512 bool) (savedFile, error) {
+	return saveHashed(ioutil.Discard, r, math.MaxInt64-1, withSha512)
+}
@@ -0,0 +1,388 @@
+package breadcrumbs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	hcl2FileSuffix        = ".pkr.hcl"
+	hcl2JsonFileSuffix    = ".pkr.json"
+	hcl2VariableStart     = "${"
+	hcl2VariableEnd       = "}"
+	hcl2VariableEnvPrefix = "PKR_VAR_"
+)
+
+var (
+	hcl2VariablesSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "variable", LabelNames: []string{"name"}},
+			{Type: "variables"},
+			{Type: "locals"},
+		},
+	}
+
+	hcl2VariableBlockSchema = &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "default"},
+			{Name: "sensitive"},
+		},
+	}
+)
+
+func isHcl2TemplateFile(filePath string) bool {
+	return strings.HasSuffix(filePath, hcl2FileSuffix) || strings.HasSuffix(filePath, hcl2JsonFileSuffix)
+}
+
+// hcl2TemplateStrings parses a set of HCL2 packer template files and
+// returns every string value found in them. Variables, locals, and
+// 'path.root' are resolved where possible. Expressions that cannot be
+// resolved (e.g., 'build.ID') are left in place as '${...}'.
+func hcl2TemplateStrings(files []templateFile, projectDirPath string, userVars map[string]string) ([]templateString, error) {
+	parsed, err := parseHcl2TemplateFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := newHcl2EvalContext(parsed, projectDirPath, userVars)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []templateString
+
+	for i, f := range files {
+		fileName := templateFileName(f.path)
+
+		body, isNative := parsed[i].Body.(*hclsyntax.Body)
+		if isNative {
+			walkHcl2Body(body, "", ctx, f.raw, func(pointer string, value string) {
+				results = append(results, templateString{
+					file:    fileName,
+					pointer: pointer,
+					value:   value,
+				})
+			})
+			continue
+		}
+
+		// JSON flavored HCL2 templates are walked like regular JSON
+		// templates, but each string is treated as an HCL2 template.
+		strs, err := jsonTemplateStrings(f.raw)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, str := range strs {
+			expr, diags := hclsyntax.ParseTemplate([]byte(str.value), f.path, hcl.InitialPos)
+			if diags.HasErrors() {
+				results = append(results, templateString{
+					file:    fileName,
+					pointer: str.pointer,
+					value:   str.value,
+				})
+				continue
+			}
+
+			walkHcl2Expression(expr, str.pointer, ctx, []byte(str.value), func(pointer string, value string) {
+				results = append(results, templateString{
+					file:    fileName,
+					pointer: pointer,
+					value:   value,
+				})
+			})
+		}
+	}
+
+	return results, nil
+}
+
+func parseHcl2TemplateFiles(files []templateFile) ([]*hcl.File, error) {
+	parser := hclparse.NewParser()
+
+	var parsed []*hcl.File
+
+	for _, f := range files {
+		var file *hcl.File
+		var diags hcl.Diagnostics
+
+		if strings.HasSuffix(f.path, hcl2JsonFileSuffix) {
+			file, diags = parser.ParseJSON(f.raw, f.path)
+		} else {
+			file, diags = parser.ParseHCL(f.raw, f.path)
+		}
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("failed to parse hcl2 packer template - %s", diags.Error())
+		}
+
+		parsed = append(parsed, file)
+	}
+
+	return parsed, nil
+}
+
+// hcl2TemplateVariables returns the string default values of the
+// variables declared in a set of HCL2 packer template files, and the
+// names of the variables that are marked as 'sensitive'.
+func hcl2TemplateVariables(files []templateFile) (map[string]string, []string, error) {
+	parsed, err := parseHcl2TemplateFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defaults := make(map[string]string)
+	var sensitive []string
+
+	for _, file := range parsed {
+		content, _, diags := file.Body.PartialContent(hcl2VariablesSchema)
+		if diags.HasErrors() {
+			return nil, nil, fmt.Errorf("failed to decode hcl2 variables - %s", diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			if block.Type != "variable" {
+				continue
+			}
+
+			blockContent, _, diags := block.Body.PartialContent(hcl2VariableBlockSchema)
+			if diags.HasErrors() {
+				return nil, nil, fmt.Errorf("failed to decode hcl2 variable '%s' - %s",
+					block.Labels[0], diags.Error())
+			}
+
+			if attr, ok := blockContent.Attributes["default"]; ok {
+				v, diags := attr.Expr.Value(nil)
+				if !diags.HasErrors() && v.Type() == cty.String && v.IsKnown() && !v.IsNull() {
+					defaults[block.Labels[0]] = v.AsString()
+				}
+			}
+
+			if attr, ok := blockContent.Attributes["sensitive"]; ok {
+				v, diags := attr.Expr.Value(nil)
+				if !diags.HasErrors() && v.Type() == cty.Bool && v.IsKnown() && !v.IsNull() && v.True() {
+					sensitive = append(sensitive, block.Labels[0])
+				}
+			}
+		}
+	}
+
+	return defaults, sensitive, nil
+}
+
+// newHcl2EvalContext creates an evaluation context containing the 'var',
+// 'local', and 'path' objects. Variable values are taken from (in order
+// of precedence) the user variables, 'PKR_VAR_' environment variables,
+// and the variable's default value.
+func newHcl2EvalContext(files []*hcl.File, projectDirPath string, userVars map[string]string) (*hcl.EvalContext, error) {
+	vars := make(map[string]cty.Value)
+	var localAttrs []*hcl.Attribute
+
+	for _, file := range files {
+		content, _, diags := file.Body.PartialContent(hcl2VariablesSchema)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("failed to decode hcl2 variables - %s", diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			switch block.Type {
+			case "variable":
+				blockContent, _, diags := block.Body.PartialContent(hcl2VariableBlockSchema)
+				if diags.HasErrors() {
+					return nil, fmt.Errorf("failed to decode hcl2 variable '%s' - %s",
+						block.Labels[0], diags.Error())
+				}
+
+				value := cty.DynamicVal
+				if attr, ok := blockContent.Attributes["default"]; ok {
+					v, diags := attr.Expr.Value(nil)
+					if !diags.HasErrors() {
+						value = v
+					}
+				}
+
+				vars[block.Labels[0]] = value
+			case "variables":
+				attrs, diags := block.Body.JustAttributes()
+				if diags.HasErrors() {
+					return nil, fmt.Errorf("failed to decode hcl2 variables block - %s", diags.Error())
+				}
+
+				for name, attr := range attrs {
+					value, diags := attr.Expr.Value(nil)
+					if diags.HasErrors() {
+						value = cty.DynamicVal
+					}
+
+					vars[name] = value
+				}
+			case "locals":
+				attrs, diags := block.Body.JustAttributes()
+				if diags.HasErrors() {
+					return nil, fmt.Errorf("failed to decode hcl2 locals block - %s", diags.Error())
+				}
+
+				for _, attr := range attrs {
+					localAttrs = append(localAttrs, attr)
+				}
+			}
+		}
+	}
+
+	for name := range vars {
+		if v, ok := os.LookupEnv(hcl2VariableEnvPrefix + name); ok {
+			vars[name] = cty.StringVal(v)
+		}
+
+		if v, ok := userVars[name]; ok {
+			vars[name] = cty.StringVal(v)
+		}
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(vars),
+			"path": cty.ObjectVal(map[string]cty.Value{
+				"root": cty.StringVal(projectDirPath),
+			}),
+		},
+	}
+
+	// Locals may refer to other locals, so keep evaluating them
+	// until no further progress can be made. Locals that cannot
+	// be evaluated are left out of the context.
+	locals := make(map[string]cty.Value)
+
+	for progress := true; progress && len(localAttrs) > 0; {
+		progress = false
+		ctx.Variables["local"] = cty.ObjectVal(locals)
+
+		var remaining []*hcl.Attribute
+
+		for _, attr := range localAttrs {
+			value, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() {
+				remaining = append(remaining, attr)
+				continue
+			}
+
+			locals[attr.Name] = value
+			progress = true
+		}
+
+		localAttrs = remaining
+	}
+
+	ctx.Variables["local"] = cty.ObjectVal(locals)
+
+	return ctx, nil
+}
+
+func walkHcl2Body(body *hclsyntax.Body, pointer string, ctx *hcl.EvalContext, src []byte, fn func(string, string)) {
+	attrs := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attr := range body.Attributes {
+		attrs = append(attrs, attr)
+	}
+
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].SrcRange.Start.Byte < attrs[j].SrcRange.Start.Byte
+	})
+
+	for _, attr := range attrs {
+		walkHcl2Expression(attr.Expr, pointer+"/"+escapeJsonPointer(attr.Name), ctx, src, fn)
+	}
+
+	for _, block := range body.Blocks {
+		blockPointer := pointer + "/" + escapeJsonPointer(block.Type)
+		for _, label := range block.Labels {
+			blockPointer = blockPointer + "/" + escapeJsonPointer(label)
+		}
+
+		walkHcl2Body(block.Body, blockPointer, ctx, src, fn)
+	}
+}
+
+// walkHcl2Expression calls fn for every string produced by expr. If the
+// expression cannot be fully evaluated, its parts are walked instead so
+// that references inside of function calls and templates are still found.
+func walkHcl2Expression(expr hclsyntax.Expression, pointer string, ctx *hcl.EvalContext, src []byte, fn func(string, string)) {
+	value, diags := expr.Value(ctx)
+	if !diags.HasErrors() && value.IsWhollyKnown() {
+		walkCtyStrings(value, pointer, fn)
+		return
+	}
+
+	switch e := expr.(type) {
+	case *hclsyntax.TemplateExpr:
+		fn(pointer, partialHcl2Template(e, ctx, src))
+	case *hclsyntax.TemplateWrapExpr:
+		walkHcl2Expression(e.Wrapped, pointer, ctx, src, fn)
+	case *hclsyntax.TupleConsExpr:
+		for i := range e.Exprs {
+			walkHcl2Expression(e.Exprs[i], pointer+"/"+strconv.Itoa(i), ctx, src, fn)
+		}
+	case *hclsyntax.ObjectConsExpr:
+		for i, item := range e.Items {
+			key := strconv.Itoa(i)
+			keyValue, diags := item.KeyExpr.Value(ctx)
+			if !diags.HasErrors() && keyValue.IsKnown() && keyValue.Type() == cty.String {
+				key = keyValue.AsString()
+			}
+
+			walkHcl2Expression(item.ValueExpr, pointer+"/"+escapeJsonPointer(key), ctx, src, fn)
+		}
+	case *hclsyntax.FunctionCallExpr:
+		for i := range e.Args {
+			walkHcl2Expression(e.Args[i], pointer+"/"+strconv.Itoa(i), ctx, src, fn)
+		}
+	}
+}
+
+// partialHcl2Template renders the parts of a template that can be
+// evaluated, and leaves the rest as '${...}'.
+func partialHcl2Template(expr *hclsyntax.TemplateExpr, ctx *hcl.EvalContext, src []byte) string {
+	var result string
+
+	for _, part := range expr.Parts {
+		value, diags := part.Value(ctx)
+		if !diags.HasErrors() && value.IsWhollyKnown() && !value.IsNull() && value.Type() == cty.String {
+			result = result + value.AsString()
+			continue
+		}
+
+		result = result + hcl2VariableStart + string(part.Range().SliceBytes(src)) + hcl2VariableEnd
+	}
+
+	return result
+}
+
+func walkCtyStrings(value cty.Value, pointer string, fn func(string, string)) {
+	if !value.IsKnown() || value.IsNull() {
+		return
+	}
+
+	t := value.Type()
+
+	switch {
+	case t == cty.String:
+		fn(pointer, value.AsString())
+	case t.IsListType(), t.IsTupleType(), t.IsSetType():
+		i := 0
+		for it := value.ElementIterator(); it.Next(); i++ {
+			_, v := it.Element()
+			walkCtyStrings(v, pointer+"/"+strconv.Itoa(i), fn)
+		}
+	case t.IsMapType(), t.IsObjectType():
+		for it := value.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			walkCtyStrings(v, pointer+"/"+escapeJsonPointer(k.AsString()), fn)
+		}
+	}
+}
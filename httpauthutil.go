@@ -0,0 +1,415 @@
+package breadcrumbs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// HttpAuthRule adds credentials and headers to the requests for HTTP files
+// whose URLs match a pattern. Secrets are read from environment variables
+// or files so that they are not stored in the packer template.
+type HttpAuthRule struct {
+	// UrlPattern is matched against the URL. The scheme and port must
+	// match exactly. In the host, '*' matches a single DNS label. In the
+	// path, '*' matches any sequence of characters (including '/').
+	UrlPattern string `mapstructure:"url_pattern"`
+
+	// Username and the password (read from PasswordEnv or PasswordFile)
+	// are sent using basic authentication.
+	Username     string `mapstructure:"username"`
+	PasswordEnv  string `mapstructure:"password_env"`
+	PasswordFile string `mapstructure:"password_file"`
+
+	// The token (read from TokenEnv or TokenFile) is sent as a bearer
+	// token in the 'Authorization' header.
+	TokenEnv  string `mapstructure:"token_env"`
+	TokenFile string `mapstructure:"token_file"`
+
+	// Headers maps header names to values. HeaderEnvs maps header names
+	// to the environment variables containing their values.
+	Headers    map[string]string `mapstructure:"headers"`
+	HeaderEnvs map[string]string `mapstructure:"header_envs"`
+}
+
+// httpAuth is a compiled HttpAuthRule whose secrets have been read.
+type httpAuth struct {
+	pattern  *urlPattern
+	username string
+	password string
+	token    string
+	headers  map[string]string
+}
+
+func (o *httpAuth) apply(request *http.Request) {
+	for name, value := range o.headers {
+		request.Header.Set(name, value)
+	}
+
+	if len(o.username) > 0 {
+		request.SetBasicAuth(o.username, o.password)
+	}
+
+	if len(o.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+o.token)
+	}
+}
+
+func newHttpAuth(rule HttpAuthRule) (*httpAuth, error) {
+	if len(rule.UrlPattern) == 0 {
+		return nil, fmt.Errorf("http auth rule is missing a url_pattern")
+	}
+
+	pattern, err := newUrlPattern(rule.UrlPattern)
+	if err != nil {
+		return nil, fmt.Errorf("http auth rule '%s' - %s", rule.UrlPattern, err.Error())
+	}
+
+	auth := &httpAuth{
+		pattern:  pattern,
+		username: rule.Username,
+		headers:  make(map[string]string),
+	}
+
+	auth.password, err = readSecret("password", rule.PasswordEnv, rule.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("http auth rule '%s' - %s", rule.UrlPattern, err.Error())
+	}
+
+	if len(auth.password) > 0 && len(auth.username) == 0 {
+		return nil, fmt.Errorf("http auth rule '%s' has a password but no username", rule.UrlPattern)
+	}
+
+	auth.token, err = readSecret("token", rule.TokenEnv, rule.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("http auth rule '%s' - %s", rule.UrlPattern, err.Error())
+	}
+
+	if len(auth.token) > 0 && len(auth.username) > 0 {
+		return nil, fmt.Errorf("http auth rule '%s' cannot use both basic authentication and a token", rule.UrlPattern)
+	}
+
+	for name, value := range rule.Headers {
+		auth.headers[name] = value
+	}
+
+	for name, envName := range rule.HeaderEnvs {
+		if _, ok := auth.headers[name]; ok {
+			return nil, fmt.Errorf("http auth rule '%s' header '%s' is specified in both headers and header_envs",
+				rule.UrlPattern, name)
+		}
+
+		value, err := readSecret("header '"+name+"'", envName, "")
+		if err != nil {
+			return nil, fmt.Errorf("http auth rule '%s' - %s", rule.UrlPattern, err.Error())
+		}
+
+		auth.headers[name] = value
+	}
+
+	return auth, nil
+}
+
+// urlPattern matches URLs component by component, so that a '*' in
+// the host cannot match the characters that separate the host from
+// the rest of the URL (for example, '/' or '@').
+type urlPattern struct {
+	scheme string
+	host   *regexp.Regexp
+	port   string
+	path   *regexp.Regexp
+}
+
+func newUrlPattern(pattern string) (*urlPattern, error) {
+	i := strings.Index(pattern, "://")
+	if i <= 0 {
+		return nil, fmt.Errorf("url pattern must start with a scheme (e.g., 'https://')")
+	}
+
+	scheme := strings.ToLower(pattern[:i])
+	if strings.Contains(scheme, "*") {
+		return nil, fmt.Errorf("url pattern scheme cannot contain '*'")
+	}
+
+	authority := pattern[i+3:]
+	pathPattern := "/"
+
+	if end := strings.IndexAny(authority, "/?#"); end > -1 {
+		if authority[end] == '/' {
+			pathPattern = authority[end:]
+		} else {
+			pathPattern = "/" + authority[end:]
+		}
+
+		authority = authority[:end]
+	}
+
+	if strings.Contains(authority, "@") {
+		return nil, fmt.Errorf("url pattern cannot contain user information")
+	}
+
+	host := authority
+	port := ""
+
+	if colon := strings.LastIndex(authority, ":"); colon > strings.LastIndex(authority, "]") {
+		host = authority[:colon]
+		port = authority[colon+1:]
+
+		_, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("url pattern port '%s' is not a valid port number", port)
+		}
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if len(host) == 0 {
+		return nil, fmt.Errorf("url pattern is missing a host")
+	}
+
+	if len(port) == 0 {
+		port = defaultUrlPort(scheme)
+	}
+
+	return &urlPattern{
+		scheme: scheme,
+		host:   globRegexp(host, "[a-z0-9_-]*"),
+		port:   port,
+		path:   globRegexp(pathPattern, ".*"),
+	}, nil
+}
+
+// match returns true if u matches the pattern. The path (and query)
+// are compared in their escaped form.
+func (o *urlPattern) match(u *url.URL) bool {
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != o.scheme {
+		return false
+	}
+
+	port := u.Port()
+	if len(port) == 0 {
+		port = defaultUrlPort(scheme)
+	}
+
+	if port != o.port || !o.host.MatchString(strings.ToLower(u.Hostname())) {
+		return false
+	}
+
+	requestPath := u.EscapedPath()
+	if len(requestPath) == 0 {
+		requestPath = "/"
+	}
+
+	if len(u.RawQuery) > 0 {
+		requestPath += "?" + u.RawQuery
+	}
+
+	return o.path.MatchString(requestPath)
+}
+
+// globRegexp converts a glob into an anchored regular expression in
+// which '*' is replaced by wildcard.
+func globRegexp(glob string, wildcard string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, wildcard) + "$")
+}
+
+func defaultUrlPort(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+
+	return ""
+}
+
+// readSecret reads a secret from an environment variable or a file.
+// Trailing new lines are removed from files. An empty string is
+// returned if neither is specified.
+func readSecret(name string, envName string, filePath string) (string, error) {
+	switch {
+	case len(envName) > 0 && len(filePath) > 0:
+		return "", fmt.Errorf("%s cannot be read from both an environment variable and a file", name)
+	case len(envName) > 0:
+		value, ok := os.LookupEnv(envName)
+		if !ok || len(value) == 0 {
+			return "", fmt.Errorf("%s environment variable '%s' is not set", name, envName)
+		}
+
+		return value, nil
+	case len(filePath) > 0:
+		raw, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s file - %s", name, err.Error())
+		}
+
+		value := strings.TrimRight(string(raw), "\r\n")
+		if len(value) == 0 {
+			return "", fmt.Errorf("%s file '%s' is empty", name, filePath)
+		}
+
+		return value, nil
+	}
+
+	return "", nil
+}
+
+// httpAuthTransport adds the credentials and headers of the first
+// matching rule to each request. Because redirected requests are also
+// sent through the transport, credentials are only sent to URLs that
+// match a rule.
+type httpAuthTransport struct {
+	auths []*httpAuth
+	next  http.RoundTripper
+}
+
+func (o *httpAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	for _, auth := range o.auths {
+		if auth.pattern.match(request.URL) {
+			// A RoundTripper must not modify the request.
+			request = request.Clone(request.Context())
+			auth.apply(request)
+			break
+		}
+	}
+
+	return o.next.RoundTrip(request)
+}
+
+// newHttpProxyFunc returns a function that selects the proxy for a
+// request. The 'http_proxy', 'https_proxy', and 'no_proxy' settings
+// override the corresponding environment variables.
+func newHttpProxyFunc(config *PluginConfig) (func(*http.Request) (*url.URL, error), error) {
+	proxyConfig := httpproxy.FromEnvironment()
+
+	if len(config.HttpProxy) > 0 {
+		proxyConfig.HTTPProxy = config.HttpProxy
+	}
+
+	if len(config.HttpsProxy) > 0 {
+		proxyConfig.HTTPSProxy = config.HttpsProxy
+	}
+
+	if len(config.NoProxy) > 0 {
+		proxyConfig.NoProxy = config.NoProxy
+	}
+
+	// httpproxy ignores proxy URLs that it cannot parse, so they
+	// are checked here.
+	proxies := map[string]string{
+		"http_proxy":  proxyConfig.HTTPProxy,
+		"https_proxy": proxyConfig.HTTPSProxy,
+	}
+
+	for name, value := range proxies {
+		if len(value) == 0 {
+			continue
+		}
+
+		if !strings.Contains(value, "://") {
+			value = "http://" + value
+		}
+
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s - %s", name, err.Error())
+		}
+
+		switch {
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5":
+			return nil, fmt.Errorf("%s scheme '%s' is not supported - must be 'http', 'https', or 'socks5'",
+				name, u.Scheme)
+		case len(u.Host) == 0:
+			return nil, fmt.Errorf("%s is missing a host", name)
+		}
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return func(request *http.Request) (*url.URL, error) {
+		return proxyFunc(request.URL)
+	}, nil
+}
+
+// newHttpTransport creates the transport used to download HTTP files
+// using the configured authentication rules, CA bundle, and client
+// certificate.
+func newHttpTransport(config *PluginConfig) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if len(config.HttpCaBundlePath) > 0 {
+		raw, err := ioutil.ReadFile(config.HttpCaBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read http ca bundle - %s", err.Error())
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("http ca bundle '%s' does not contain any pem encoded certificates",
+				config.HttpCaBundlePath)
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	proxy, err := newHttpProxyFunc(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.Proxy = proxy
+
+	if len(config.HttpClientCertPath) > 0 || len(config.HttpClientKeyPath) > 0 {
+		if len(config.HttpClientCertPath) == 0 || len(config.HttpClientKeyPath) == 0 {
+			return nil, fmt.Errorf("http_client_cert_path and http_client_key_path must be specified together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.HttpClientCertPath, config.HttpClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load http client certificate - %s", err.Error())
+		}
+
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.HttpAuth) == 0 {
+		return transport, nil
+	}
+
+	authTransport := &httpAuthTransport{
+		next: transport,
+	}
+
+	for _, rule := range config.HttpAuth {
+		auth, err := newHttpAuth(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		authTransport.auths = append(authTransport.auths, auth)
+	}
+
+	return authTransport, nil
+}
@@ -5,26 +5,53 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
+)
+
+const (
+	// ManifestFileName is the name of the manifest file stored
+	// in the breadcrumbs directory.
+	ManifestFileName = "breadcrumbs.json"
 )
 
 type Manifest struct {
+	SchemaVersion   int               `json:"schema_version"`
 	PluginVersion   string            `json:"plugin_version"`
+	VCS             string            `json:"vcs"`
 	GitRevision     string            `json:"git_revision"`
+	Git             *GitInfo          `json:"git,omitempty"`
+	Mercurial       *MercurialInfo    `json:"mercurial,omitempty"`
+	TreeHash        string            `json:"tree_hash,omitempty"`
 	PackerBuildName string            `json:"packer_build_name"`
 	PackerBuildType string            `json:"packer_build_type"`
 	PackerUserVars  map[string]string `json:"packer_user_variables"`
+	RedactedVars    []string          `json:"redacted_variables,omitempty"`
 	OSName          string            `json:"os_name"`
 	OSVersion       string            `json:"os_version"`
+	OSRelease       map[string]string `json:"os_release,omitempty"`
+	Windows         *WindowsFacts     `json:"windows,omitempty"`
+	KernelRelease   string            `json:"kernel_release"`
+	Architecture    string            `json:"architecture"`
+	Virtualization  string            `json:"virtualization"`
+	BootMode        string            `json:"boot_mode"`
+	InitSystem      string            `json:"init_system"`
 	IncludeSuffixes []string          `json:"include_suffixes"`
 	PackerTemplate  string            `json:"packer_template_path"`
+	TemplateFormat  TemplateFormat    `json:"packer_template_format"`
+	TemplateFiles   []FileMeta        `json:"packer_template_files"`
 	FoundFiles      []FileMeta        `json:"found_files"`
-	pTemplateRaw    []byte            `json:"-"`
+	PackagesFile    string            `json:"packages_file,omitempty"`
+	SbomFiles       []string          `json:"sbom_files,omitempty"`
+	GeneratedFiles  []FileMeta        `json:"generated_files,omitempty"`
+	templatesRaw    map[string][]byte `json:"-"`
+	packages        *PackageInventory `json:"-"`
+	gitDiff         []byte            `json:"-"`
+	projectDirPath  string            `json:"-"`
+	secrets         map[string]string `json:"-"`
 }
 
 func (o *Manifest) ToJson() ([]byte, error) {
@@ -39,33 +66,67 @@ func (o *Manifest) ToJson() ([]byte, error) {
 }
 
 type OptionalManifestFields struct {
-	OSName    string
-	OSVersion string
+	OSName         string
+	OSVersion      string
+	OSRelease      map[string]string
+	Windows        *WindowsFacts
+	KernelRelease  string
+	Architecture   string
+	Virtualization string
+	BootMode       string
+	InitSystem     string
 }
 
 func newManifest(config *PluginConfig, optionalFields OptionalManifestFields) (*Manifest, error) {
-	info, err := os.Stat(config.TemplatePath)
+	redactor, err := newRedactor(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if info.Size() > config.TemplateSizeBytes {
-		return nil, fmt.Errorf("packer template file '%s' size exceedes maximum size of %d byte(s)",
-			config.TemplatePath, config.TemplateSizeBytes)
-	}
-
-	templateRaw, err := ioutil.ReadFile(config.TemplatePath)
+	format, templateFiles, err := loadPackerTemplate(config.TemplatePath, config.TemplateSizeBytes)
 	if err != nil {
 		return nil, err
 	}
 
+	var templateStrings []templateString
+
+	switch format {
+	case Hcl2Template:
+		templateStrings, err = hcl2TemplateStrings(templateFiles, config.ProjectDirPath, config.PackerUserVars)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		templateStrings, err = jsonTemplateStrings(templateFiles[0].raw)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range templateStrings {
+			templateStrings[i].file = templateFileName(templateFiles[0].path)
+		}
+	}
+
 	var foundFileMetas []FileMeta
 
 	for i := range config.IncludeSuffixes {
-		results, unresolvedIndexes := filesWithSuffixRecursive([]byte(config.IncludeSuffixes[i]), templateRaw, []FileMeta{}, []int{})
+		results := filesWithSuffix(config.IncludeSuffixes[i], templateStrings)
+
+		for index := range results {
+			if !results[index].unresolved {
+				continue
+			}
+
+			foundIn := results[index].FoundInTemplate
+			pointer := results[index].JsonPointer
+
+			// HCL2 variables have already been resolved as much as
+			// possible - anything left over cannot be resolved.
+			resolution := packerResolutionResult{result: missingVar}
+			if format == JsonTemplate {
+				resolution = resolvePackerVariables(results[index].FoundAtPath, config.PackerUserVars)
+			}
 
-		for _, index := range unresolvedIndexes {
-			resolution := resolvePackerVariables(results[index].FoundAtPath, config.PackerUserVars)
 			switch resolution.result {
 			case unknownVarType:
 				return nil, resolution.err
@@ -84,87 +145,94 @@ func newManifest(config *PluginConfig, optionalFields OptionalManifestFields) (*
 			default:
 				results[index] = newFileMeta(resolution.str)
 			}
+
+			results[index].FoundInTemplate = foundIn
+			results[index].JsonPointer = pointer
 		}
 
 		foundFileMetas = append(foundFileMetas, results...)
 	}
 
-	gitRev, err := currentGitRevision(config.ProjectDirPath)
+	var defaultVars map[string]string
+	var sensitiveVarNames []string
+
+	switch format {
+	case Hcl2Template:
+		defaultVars, sensitiveVarNames, err = hcl2TemplateVariables(templateFiles)
+	default:
+		defaultVars, sensitiveVarNames, err = jsonTemplateVariables(templateFiles[0].raw)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	manifest := &Manifest{
-		PluginVersion:   config.PluginVersion,
-		GitRevision:     gitRev,
-		PackerBuildName: config.PackerBuildName,
-		PackerBuildType: config.PackerBuilderType,
-		PackerUserVars:  config.PackerUserVars,
-		PackerTemplate:  hashBytes([]byte(path.Base(config.TemplatePath))),
-		IncludeSuffixes: config.IncludeSuffixes,
-		OSName:          optionalFields.OSName,
-		OSVersion:       optionalFields.OSVersion,
-		FoundFiles:      foundFileMetas,
-		pTemplateRaw:    templateRaw,
-	}
-
-	return manifest, nil
-}
-
-func filesWithSuffixRecursive(suffix []byte, raw []byte, metas []FileMeta, unresolvedIndexes []int) ([]FileMeta, []int) {
-	resultRaw, endIndex, wasFound := fileWithSuffix(suffix, raw)
-	if wasFound {
-		if len(resultRaw) != len(suffix) {
-			result := string(resultRaw)
-			if strings.ContainsAny(result, packerVariableDelims) {
-				unresolvedIndexes = append(unresolvedIndexes, len(metas))
-				metas = append(metas, newUnresolvedFileMeta(result))
-			} else {
-				metas = append(metas, newFileMeta(result))
-			}
+	// Packer provides the values (rather than the names) of the
+	// template's sensitive variables.
+	userVars, redactedVars, secrets := redactor.redactVariables(config.PackerUserVars, defaultVars,
+		sensitiveVarNames, config.PackerSensitiveVars)
+
+	// Found files can contain secrets if they were created by
+	// resolving user variables (e.g., a token in a query string).
+	// They are still fetched from their unredacted location. The
+	// unsalted hash of that location would allow guessed secrets
+	// to be confirmed, so it is not used as the stored file name.
+	for i := range foundFileMetas {
+		redacted := redactText(foundFileMetas[i].FoundAtPath, secrets)
+		if redacted == foundFileMetas[i].FoundAtPath {
+			continue
 		}
 
-		return filesWithSuffixRecursive(suffix, raw[endIndex:], metas, unresolvedIndexes)
+		foundFileMetas[i].sourcePath = foundFileMetas[i].FoundAtPath
+		foundFileMetas[i].FoundAtPath = redacted
+		foundFileMetas[i].StoredAtPath = redactor.storedPath(foundFileMetas[i].sourcePath)
+		foundFileMetas[i].Name = redactText(foundFileMetas[i].Name, secrets)
 	}
 
-	return metas, unresolvedIndexes
-}
+	var packerTemplate string
+	templateMetas := make([]FileMeta, len(templateFiles))
+	templatesRaw := make(map[string][]byte)
 
-func fileWithSuffix(suffix []byte, raw []byte) (result []byte, endDelimIndex int, wasFound bool) {
-	suffixStartIndex := bytes.Index(raw, suffix)
-	if suffixStartIndex < 0 {
-		return nil, 0, false
+	for i := range templateFiles {
+		templateMetas[i] = newTemplateFileMeta(templateFiles[i])
+		templatesRaw[templateMetas[i].StoredAtPath] = redactTemplate(templateFiles[i].raw, secrets)
 	}
 
-	endDelimIndex = suffixStartIndex + len(suffix)
-
-	delim := doubleQuoteChar
-	if len(raw) - 1 >= endDelimIndex && bytes.ContainsAny([]byte{raw[endDelimIndex]}, possibleDelims) {
-		delim = raw[endDelimIndex]
+	if len(templateFiles) == 1 && templateFiles[0].path == config.TemplatePath {
+		packerTemplate = templateMetas[0].StoredAtPath
 	}
 
-	startIndex := bytes.LastIndexByte(raw[:suffixStartIndex], delim)
-	if startIndex < 0 || startIndex+1 > endDelimIndex {
-		return nil, 0, false
+	manifest := &Manifest{
+		SchemaVersion:   ManifestSchemaVersion,
+		PluginVersion:   config.PluginVersion,
+		PackerBuildName: config.PackerBuildName,
+		PackerBuildType: config.PackerBuilderType,
+		PackerUserVars:  userVars,
+		RedactedVars:    redactedVars,
+		PackerTemplate:  packerTemplate,
+		TemplateFormat:  format,
+		TemplateFiles:   templateMetas,
+		IncludeSuffixes: config.IncludeSuffixes,
+		OSName:          optionalFields.OSName,
+		OSVersion:       optionalFields.OSVersion,
+		OSRelease:       optionalFields.OSRelease,
+		Windows:         optionalFields.Windows,
+		KernelRelease:   optionalFields.KernelRelease,
+		Architecture:    optionalFields.Architecture,
+		Virtualization:  optionalFields.Virtualization,
+		BootMode:        optionalFields.BootMode,
+		InitSystem:      optionalFields.InitSystem,
+		FoundFiles:      foundFileMetas,
+		templatesRaw:    templatesRaw,
+		projectDirPath:  config.ProjectDirPath,
+		secrets:         secrets,
 	}
 
-	endPackerVarIndex := bytes.Index(raw[startIndex:endDelimIndex], endPackerVariableBytes)
-	if endPackerVarIndex > 0 {
-		// TODO: Big assumption about line ending.
-		lineStartIndex := bytes.LastIndex(raw[:endDelimIndex], newLineBytes)
-		if lineStartIndex < 0 {
-			lineStartIndex = 0
-		}
-		varOpenIndex := bytes.Index(raw[lineStartIndex:endDelimIndex], startPackerVariableBytes)
-		if varOpenIndex >= 0 {
-			startIndex = lineStartIndex + varOpenIndex
-		}
-	} else {
-		// Increase start index by delim len.
-		startIndex++
+	err = recordVcs(manifest, config)
+	if err != nil {
+		return nil, err
 	}
 
-	return raw[startIndex:endDelimIndex], endDelimIndex, true
+	return manifest, nil
 }
 
 func findFileInDirRecursive(fileName string, dirPath string) (string, error) {
@@ -208,6 +276,18 @@ func newUnresolvedFileMeta(str string) FileMeta {
 	}
 }
 
+func newTemplateFileMeta(file templateFile) FileMeta {
+	name := templateFileName(file.path)
+
+	return FileMeta{
+		Name:         name,
+		FoundAtPath:  name,
+		StoredAtPath: hashBytes([]byte(name)),
+		Source:       LocalStorage,
+		ModTime:      file.modTime.UTC().Format(time.RFC3339),
+	}
+}
+
 func newFileMeta(filePath string) FileMeta {
 	fm := FileMeta{
 		Name:         filepath.Base(filePath),
@@ -0,0 +1,125 @@
+package breadcrumbs
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mirror rewrites URLs that start with a prefix.
+type mirror struct {
+	prefix string
+
+	// target is either a URL prefix, or a local directory
+	// if isDir is true.
+	target string
+	isDir  bool
+}
+
+// mirrorMap rewrites the URLs of HTTP files to internal mirrors or local
+// directories before they are fetched. The longest matching prefix
+// is used.
+type mirrorMap struct {
+	mirrors []mirror
+}
+
+func newMirrorMap(config *PluginConfig) (*mirrorMap, error) {
+	m := &mirrorMap{}
+
+	for prefix, target := range config.Mirrors {
+		if !strings.HasPrefix(prefix, httpFilePrefix) && !strings.HasPrefix(prefix, httpsFilePrefix) {
+			return nil, fmt.Errorf("mirror prefix '%s' must start with '%s' or '%s'",
+				prefix, httpFilePrefix, httpsFilePrefix)
+		}
+
+		mir := mirror{
+			prefix: prefix,
+			target: target,
+		}
+
+		switch {
+		case strings.HasPrefix(target, httpFilePrefix), strings.HasPrefix(target, httpsFilePrefix):
+			_, err := url.Parse(target)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse mirror '%s' - %s", target, err.Error())
+			}
+		case strings.HasPrefix(target, "file://"):
+			u, err := url.Parse(target)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse mirror '%s' - %s", target, err.Error())
+			}
+
+			mir.target = filepath.FromSlash(u.Path)
+			mir.isDir = true
+		case filepath.IsAbs(target):
+			mir.isDir = true
+		default:
+			return nil, fmt.Errorf("mirror '%s' for '%s' must be an http(s) url or an absolute directory path",
+				target, prefix)
+		}
+
+		m.mirrors = append(m.mirrors, mir)
+	}
+
+	sort.Slice(m.mirrors, func(i, j int) bool {
+		return len(m.mirrors[i].prefix) > len(m.mirrors[j].prefix)
+	})
+
+	return m, nil
+}
+
+// resolve returns the location of an HTTP file. The returned path is a
+// URL, or a local file path if isLocal is true. The URL is returned
+// unmodified if it does not match a mirror.
+func (o *mirrorMap) resolve(fileUrl string) (string, bool, error) {
+	for _, mir := range o.mirrors {
+		if !matchesUrlPrefix(fileUrl, mir.prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(fileUrl, mir.prefix)
+
+		if !mir.isDir {
+			return mir.target + rest, false, nil
+		}
+
+		// Query strings and fragments are not part of the file's path.
+		if i := strings.IndexAny(rest, "?#"); i >= 0 {
+			rest = rest[:i]
+		}
+
+		rest, err := url.PathUnescape(rest)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to unescape '%s' - %s", fileUrl, err.Error())
+		}
+
+		cleaned := path.Clean("/" + rest)
+		if cleaned == "/" {
+			return "", false, fmt.Errorf("url '%s' does not contain a file path after mirror prefix '%s'",
+				fileUrl, mir.prefix)
+		}
+
+		return filepath.Join(mir.target, filepath.FromSlash(cleaned)), true, nil
+	}
+
+	return fileUrl, false, nil
+}
+
+// matchesUrlPrefix returns true if fileUrl starts with prefix, and the
+// prefix ends at a path segment boundary. For example, the prefix
+// 'https://example.com/isos' matches 'https://example.com/isos/a.iso',
+// but not 'https://example.com/isos-old/a.iso'.
+func matchesUrlPrefix(fileUrl string, prefix string) bool {
+	if !strings.HasPrefix(fileUrl, prefix) {
+		return false
+	}
+
+	if strings.HasSuffix(prefix, "/") || len(fileUrl) == len(prefix) {
+		return true
+	}
+
+	return strings.ContainsRune("/?#", rune(fileUrl[len(prefix)]))
+}
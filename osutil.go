@@ -3,6 +3,10 @@ package breadcrumbs
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
 	"strings"
 	"unicode"
 
@@ -16,18 +20,194 @@ const (
 	windows osCategory = "windows"
 )
 
-func getOSCategory(c packer.Communicator) osCategory {
+const (
+	defaultUnixUploadDirPath    = "/var/lib/breadcrumbs"
+	defaultWindowsUploadDirPath = `C:\ProgramData\breadcrumbs`
+)
+
+func defaultUploadDirPath(category osCategory) string {
+	if category == windows {
+		return defaultWindowsUploadDirPath
+	}
+
+	return defaultUnixUploadDirPath
+}
+
+func normalizeGuestPath(category osCategory, p string) string {
+	if category == windows {
+		return strings.Replace(p, "/", `\`, -1)
+	}
+
+	return path.Clean(p)
+}
+
+func createGuestDir(ctx context.Context, c packer.Communicator, category osCategory, dirPath string) error {
+	var command string
+
+	switch category {
+	case windows:
+		command = fmt.Sprintf("powershell -NoProfile -NonInteractive -Command \"New-Item -ItemType Directory -Force -Path '%s' | Out-Null\"",
+			strings.Replace(dirPath, "'", "''", -1))
+	default:
+		command = fmt.Sprintf("mkdir -p '%s'", strings.Replace(dirPath, "'", `'\''`, -1))
+	}
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := &packer.RemoteCmd{
+		Command: command,
+		Stderr:  stderr,
+	}
+
+	err := runRemoteCmd(ctx, c, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to create directory '%s' on guest - %s", dirPath, err.Error())
+	}
+
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("failed to create directory '%s' on guest - command exited with status %d - stderr: '%s'",
+			dirPath, cmd.ExitStatus(), strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func guestDirExists(ctx context.Context, c packer.Communicator, category osCategory, dirPath string) (bool, error) {
+	var command string
+
+	switch category {
+	case windows:
+		command = fmt.Sprintf("powershell -NoProfile -NonInteractive -Command \"if (Test-Path -PathType Container -Path '%s') { exit 0 } else { exit 1 }\"",
+			strings.Replace(dirPath, "'", "''", -1))
+	default:
+		command = fmt.Sprintf("test -d '%s'", strings.Replace(dirPath, "'", `'\''`, -1))
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command: command,
+	}
+
+	err := runRemoteCmd(ctx, c, cmd)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if directory '%s' exists on guest - %s", dirPath, err.Error())
+	}
+
+	return cmd.ExitStatus() == 0, nil
+}
+
+func removeGuestDir(ctx context.Context, c packer.Communicator, category osCategory, dirPath string) error {
+	var command string
+
+	switch category {
+	case windows:
+		command = fmt.Sprintf("powershell -NoProfile -NonInteractive -Command \"Remove-Item -Recurse -Force -Path '%s'\"",
+			strings.Replace(dirPath, "'", "''", -1))
+	default:
+		command = fmt.Sprintf("rm -rf '%s'", strings.Replace(dirPath, "'", `'\''`, -1))
+	}
+
+	cmd := &packer.RemoteCmd{
+		Command: command,
+	}
+
+	err := runRemoteCmd(ctx, c, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to remove directory '%s' from guest - %s", dirPath, err.Error())
+	}
+
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("failed to remove directory '%s' from guest - command exited with status %d",
+			dirPath, cmd.ExitStatus())
+	}
+
+	return nil
+}
+
+// newGuestStagingDirPath returns the path of a new directory in
+// dirPath that the breadcrumbs are uploaded to before they are moved
+// into dirPath.
+func newGuestStagingDirPath(category osCategory, dirPath string) (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate staging directory name - %s", err.Error())
+	}
+
+	name := ".breadcrumbs-upload-" + hex.EncodeToString(b)
+
+	if category == windows {
+		return strings.TrimSuffix(dirPath, `\`) + `\` + name, nil
+	}
+
+	return path.Join(dirPath, name), nil
+}
+
+// moveGuestDirContents moves the contents of srcDirPath into dstDirPath,
+// and then removes srcDirPath. Files in dstDirPath are replaced.
+func moveGuestDirContents(ctx context.Context, c packer.Communicator, category osCategory, srcDirPath string, dstDirPath string) error {
+	var command string
+
+	switch category {
+	case windows:
+		command = fmt.Sprintf("powershell -NoProfile -NonInteractive -Command \"$ErrorActionPreference = 'Stop'; Move-Item -Force -Path '%s\\*' -Destination '%s'; Remove-Item -Force -Path '%s'\"",
+			strings.Replace(srcDirPath, "'", "''", -1), strings.Replace(dstDirPath, "'", "''", -1),
+			strings.Replace(srcDirPath, "'", "''", -1))
+	default:
+		command = fmt.Sprintf("mv -f '%s'/* '%s'/ && rmdir '%s'",
+			strings.Replace(srcDirPath, "'", `'\''`, -1), strings.Replace(dstDirPath, "'", `'\''`, -1),
+			strings.Replace(srcDirPath, "'", `'\''`, -1))
+	}
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := &packer.RemoteCmd{
+		Command: command,
+		Stderr:  stderr,
+	}
+
+	err := runRemoteCmd(ctx, c, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to move breadcrumbs from '%s' to '%s' on guest - %s", srcDirPath, dstDirPath, err.Error())
+	}
+
+	if cmd.ExitStatus() != 0 {
+		return fmt.Errorf("failed to move breadcrumbs from '%s' to '%s' on guest - command exited with status %d - stderr: '%s'",
+			srcDirPath, dstDirPath, cmd.ExitStatus(), strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// runRemoteCmd starts cmd and waits for it to exit. It returns early
+// with the context's error if the context is done before cmd exits.
+func runRemoteCmd(ctx context.Context, c packer.Communicator, cmd *packer.RemoteCmd) error {
+	err := c.Start(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func getOSCategory(ctx context.Context, c packer.Communicator) osCategory {
 	ls := &packer.RemoteCmd{
 		Command: "ls",
 	}
 
-	err := c.Start(context.TODO(), ls)
+	err := runRemoteCmd(ctx, c, ls)
 	if err != nil {
 		return osCategory("unknown")
 	}
 
-	ls.Wait()
-
 	if ls.ExitStatus() == 0 {
 		return unix
 	}
@@ -35,20 +215,332 @@ func getOSCategory(c packer.Communicator) osCategory {
 	return windows
 }
 
-func isRedHat(c packer.Communicator) (string, string, bool) {
+// detectOS determines the guest's operating system name and version.
+// Windows facts are queried using PowerShell, with 'ver' used as a
+// fallback.
+func detectOS(ctx context.Context, c packer.Communicator, category osCategory) OptionalManifestFields {
+	var optionalFields OptionalManifestFields
+
+	switch category {
+	case unix:
+		kernelName, _ := remoteOutput(ctx, c, "uname -s")
+		optionalFields = detectUnixOS(ctx, c, kernelName)
+		addUnixSystemFacts(ctx, c, kernelName, &optionalFields)
+	case windows:
+		optionalFields.OSName = "windows"
+
+		facts, err := windowsFacts(ctx, c)
+		if err == nil {
+			optionalFields.OSVersion = facts.FullVersion()
+			optionalFields.Windows = facts
+			addWindowsSystemFacts(facts, &optionalFields)
+			break
+		}
+
+		// Older machines may not have PowerShell or CIM.
+		optionalFields.OSVersion = windowsVersion(ctx, c)
+	}
+
+	return optionalFields
+}
+
+// detectUnixOS determines the operating system of a unix-like machine.
+// The os-release file is preferred, with distribution specific files
+// used as fallbacks for older distributions. BSDs are identified by
+// their kernel name, since their version is not always recorded in an
+// os-release file. illumos distributions usually have an os-release
+// file, but 'uname' is used as a fallback.
+func detectUnixOS(ctx context.Context, c packer.Communicator, kernelName string) OptionalManifestFields {
+	var optionalFields OptionalManifestFields
+
+	switch kernelName {
+	case "FreeBSD":
+		optionalFields.OSName = "freebsd"
+		// 'freebsd-version' reports the userland version, which
+		// includes the patch level. It was added in FreeBSD 10.
+		version, ok := remoteOutput(ctx, c, "freebsd-version -u")
+		if !ok {
+			version, _ = remoteOutput(ctx, c, "uname -r")
+		}
+		optionalFields.OSVersion = bsdVersion(version)
+		return optionalFields
+	case "OpenBSD", "NetBSD", "DragonFly":
+		optionalFields.OSName = strings.ToLower(kernelName)
+		release, _ := remoteOutput(ctx, c, "uname -r")
+		optionalFields.OSVersion = bsdVersion(release)
+		return optionalFields
+	}
+
+	fields, ok := osRelease(ctx, c)
+	if ok {
+		optionalFields.OSName, optionalFields.OSVersion = osReleaseNameAndVersion(fields)
+		optionalFields.OSRelease = fields
+		return optionalFields
+	}
+
+	if kernelName == "SunOS" {
+		optionalFields.OSName, optionalFields.OSVersion = sunOSNameAndVersion(ctx, c)
+		return optionalFields
+	}
+
+	optionalFields.OSName, optionalFields.OSVersion, ok = isRedHat(ctx, c)
+	if ok {
+		return optionalFields
+	}
+
+	optionalFields.OSName, optionalFields.OSVersion, ok = isDebian(ctx, c)
+	if ok {
+		return optionalFields
+	}
+
+	optionalFields.OSName, optionalFields.OSVersion, _ = isMacos(ctx, c)
+
+	return optionalFields
+}
+
+// addUnixSystemFacts adds the kernel release, architecture,
+// virtualization type, boot mode, and init system of a unix-like
+// machine to optionalFields.
+func addUnixSystemFacts(ctx context.Context, c packer.Communicator, kernelName string, optionalFields *OptionalManifestFields) {
+	optionalFields.KernelRelease, _ = remoteOutput(ctx, c, "uname -r")
+	optionalFields.Architecture, _ = remoteOutput(ctx, c, "uname -m")
+	optionalFields.Virtualization = unixVirtualization(ctx, c)
+	optionalFields.BootMode = unixBootMode(ctx, c, kernelName, optionalFields.Architecture)
+	optionalFields.InitSystem = unixInitSystem(ctx, c)
+}
+
+// unixVirtualization returns the virtualization type using the same
+// names as 'systemd-detect-virt' (e.g., 'kvm', 'vmware', or 'none').
+// FreeBSD's 'kern.vm_guest' is used as a fallback. An empty string
+// is returned if the type cannot be determined.
+func unixVirtualization(ctx context.Context, c packer.Communicator) string {
+	// 'systemd-detect-virt' prints 'none' and exits with a non-zero
+	// status when no virtualization is detected, so only its output
+	// is checked.
+	stdout := bytes.NewBuffer(nil)
+	detectVirt := &packer.RemoteCmd{
+		Command: "systemd-detect-virt",
+		Stdout:  stdout,
+	}
+
+	err := runRemoteCmd(ctx, c, detectVirt)
+	if err == nil && len(strings.TrimSpace(stdout.String())) > 0 {
+		return strings.TrimSpace(stdout.String())
+	}
+
+	vmGuest, ok := remoteOutput(ctx, c, "sysctl -n kern.vm_guest")
+	if ok && len(vmGuest) > 0 {
+		return vmGuest
+	}
+
+	return ""
+}
+
+// unixBootMode returns 'uefi' or 'bios', or an empty string if the boot
+// mode cannot be determined. Linux machines are assumed to have booted
+// using BIOS if they do not expose EFI variables, but only on x86 (other
+// architectures do not have a BIOS).
+func unixBootMode(ctx context.Context, c packer.Communicator, kernelName string, arch string) string {
+	switch kernelName {
+	case "Linux":
+		_, isUefi := remoteOutput(ctx, c, "test -d /sys/firmware/efi")
+		if isUefi {
+			return "uefi"
+		}
+
+		switch arch {
+		case "x86_64", "i386", "i486", "i586", "i686":
+			return "bios"
+		}
+	case "FreeBSD":
+		method, ok := remoteOutput(ctx, c, "sysctl -n machdep.bootmethod")
+		if ok {
+			return strings.ToLower(method)
+		}
+	}
+
+	return ""
+}
+
+// unixInitSystem returns the name of the process running as PID 1
+// (e.g., 'systemd' or 'launchd'). OpenRC is reported as 'openrc',
+// since it is usually started by an init process named 'init'.
+func unixInitSystem(ctx context.Context, c packer.Communicator) string {
+	comm, ok := remoteOutput(ctx, c, "cat /proc/1/comm 2>/dev/null || ps -p 1 -o comm=")
+	if !ok || len(comm) == 0 {
+		return ""
+	}
+
+	name := path.Base(comm)
+
+	if name == "init" {
+		_, isOpenRC := remoteOutput(ctx, c, "test -d /run/openrc")
+		if isOpenRC {
+			return "openrc"
+		}
+	}
+
+	return name
+}
+
+// remoteOutput runs a command and returns its trimmed standard output.
+// It returns false if the command could not be run or exited with a
+// non-zero status.
+func remoteOutput(ctx context.Context, c packer.Communicator, command string) (string, bool) {
+	stdout := bytes.NewBuffer(nil)
+	cmd := &packer.RemoteCmd{
+		Command: command,
+		Stdout:  stdout,
+	}
+
+	err := runRemoteCmd(ctx, c, cmd)
+	if err != nil {
+		return "", false
+	}
+
+	if cmd.ExitStatus() != 0 {
+		return "", false
+	}
+
+	return strings.TrimSpace(stdout.String()), true
+}
+
+// bsdVersion returns the version from the output of 'freebsd-version'
+// or 'uname -r' (e.g., '13.2-RELEASE-p4', '7.4', or '10.0_RC1').
+func bsdVersion(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// sunOSNameAndVersion returns the name and version of a SunOS machine.
+// 'uname -o' distinguishes illumos from Oracle Solaris, and 'uname -v'
+// reports the version (e.g., '11.4.0.15.0' on Solaris, or the build
+// on illumos, such as 'omnios-r151046-0a9d1b3a42').
+func sunOSNameAndVersion(ctx context.Context, c packer.Communicator) (string, string) {
+	name := "solaris"
+
+	osName, _ := remoteOutput(ctx, c, "uname -o")
+	if strings.EqualFold(osName, "illumos") {
+		name = "illumos"
+	}
+
+	version, _ := remoteOutput(ctx, c, "uname -v")
+
+	return name, version
+}
+
+// osRelease reads and parses the guest's os-release file. It returns
+// false if the file does not exist or does not contain an 'ID' field.
+func osRelease(ctx context.Context, c packer.Communicator) (map[string]string, bool) {
+	stdout := bytes.NewBuffer(nil)
+	cat := &packer.RemoteCmd{
+		Command: "cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release",
+		Stdout:  stdout,
+	}
+
+	err := runRemoteCmd(ctx, c, cat)
+	if err != nil {
+		return nil, false
+	}
+
+	if cat.ExitStatus() != 0 {
+		return nil, false
+	}
+
+	fields := parseOSRelease(stdout.String())
+	if len(fields["ID"]) == 0 {
+		return nil, false
+	}
+
+	return fields, true
+}
+
+// parseOSRelease parses the contents of an os-release file as described
+// by os-release(5). Values may be unquoted, or quoted using single or
+// double quotes.
+func parseOSRelease(s string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		equalsIndex := strings.Index(line, "=")
+		if equalsIndex < 1 {
+			continue
+		}
+
+		key := line[:equalsIndex]
+		value := line[equalsIndex+1:]
+
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+			unescaped := bytes.NewBuffer(nil)
+			for i := 0; i < len(value); i++ {
+				if value[i] == '\\' && i+1 < len(value) && strings.IndexByte("\"\\$`", value[i+1]) >= 0 {
+					i++
+				}
+				unescaped.WriteByte(value[i])
+			}
+			value = unescaped.String()
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+
+		fields[key] = value
+	}
+
+	return fields
+}
+
+// osReleaseNameAndVersion returns the OS name and version for a set of
+// os-release fields. IDs are used as the name, except where a name was
+// already used by older versions of the plugin (e.g., 'rhel' is 'redhat')
+// or where the ID is not very descriptive (e.g., 'ol' is 'oracle').
+func osReleaseNameAndVersion(fields map[string]string) (string, string) {
+	name := strings.ToLower(fields["ID"])
+
+	switch {
+	case name == "rhel":
+		name = "redhat"
+	case name == "ol":
+		name = "oracle"
+	case name == "amzn":
+		name = "amazon"
+	case name == "almalinux":
+		name = "alma"
+	case strings.HasPrefix(name, "opensuse"):
+		name = "opensuse"
+	}
+
+	version := fields["VERSION_ID"]
+	if len(version) == 0 {
+		// Rolling release distributions (e.g., Arch) may only
+		// specify a 'BUILD_ID'.
+		version = fields["BUILD_ID"]
+	}
+
+	return name, version
+}
+
+func isRedHat(ctx context.Context, c packer.Communicator) (string, string, bool) {
 	stdout := bytes.NewBuffer(nil)
 	cat := &packer.RemoteCmd{
 		Command: "cat /etc/redhat-release",
 		Stdout:  stdout,
 	}
 
-	err := c.Start(context.TODO(), cat)
+	err := runRemoteCmd(ctx, c, cat)
 	if err != nil {
 		return "", "", false
 	}
 
-	cat.Wait()
-
 	if cat.ExitStatus() != 0 {
 		return "", "", false
 	}
@@ -62,20 +554,18 @@ func isRedHat(c packer.Communicator) (string, string, bool) {
 	return name, getVersion(outStr), true
 }
 
-func isDebian(c packer.Communicator) (string, string, bool) {
+func isDebian(ctx context.Context, c packer.Communicator) (string, string, bool) {
 	stdout := bytes.NewBuffer(nil)
 	cat := &packer.RemoteCmd{
 		Command: "cat /etc/issue",
 		Stdout:  stdout,
 	}
 
-	err := c.Start(context.TODO(), cat)
+	err := runRemoteCmd(ctx, c, cat)
 	if err != nil {
 		return "", "", false
 	}
 
-	cat.Wait()
-
 	if cat.ExitStatus() != 0 {
 		return "", "", false
 	}
@@ -89,20 +579,18 @@ func isDebian(c packer.Communicator) (string, string, bool) {
 	return name, getVersion(outStr), true
 }
 
-func isMacos(c packer.Communicator) (string, string, bool) {
+func isMacos(ctx context.Context, c packer.Communicator) (string, string, bool) {
 	stdout := bytes.NewBuffer(nil)
 	swVers := &packer.RemoteCmd{
 		Command: "sw_vers",
 		Stdout:  stdout,
 	}
 
-	err := c.Start(context.TODO(), swVers)
+	err := runRemoteCmd(ctx, c, swVers)
 	if err != nil {
 		return "", "", false
 	}
 
-	swVers.Wait()
-
 	if swVers.ExitStatus() != 0 {
 		return "", "", false
 	}
@@ -110,20 +598,18 @@ func isMacos(c packer.Communicator) (string, string, bool) {
 	return "macos", getVersion(stdout.String()), true
 }
 
-func windowsVersion(c packer.Communicator) string {
+func windowsVersion(ctx context.Context, c packer.Communicator) string {
 	stdout := bytes.NewBuffer(nil)
 	ver := &packer.RemoteCmd{
 		Command: "ver",
 		Stdout:  stdout,
 	}
 
-	err := c.Start(context.TODO(), ver)
+	err := runRemoteCmd(ctx, c, ver)
 	if err != nil {
 		return ""
 	}
 
-	ver.Wait()
-
 	return getVersion(stdout.String())
 }
 
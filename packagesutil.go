@@ -0,0 +1,513 @@
+package breadcrumbs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+const (
+	// PackagesFileName is the name of the package list file stored
+	// in the breadcrumbs directory when 'capture_packages' is enabled.
+	PackagesFileName = "packages.json"
+)
+
+const (
+	rpmPackageManager     = "rpm"
+	dpkgPackageManager    = "dpkg"
+	apkPackageManager     = "apk"
+	pacmanPackageManager  = "pacman"
+	brewPackageManager    = "brew"
+	pkgPackageManager     = "pkg"
+	pkgInfoPackageManager = "pkg_info"
+	windowsPackageManager = "windows"
+)
+
+// windowsPackagesScript is a PowerShell script that prints the programs
+// listed in the registry's uninstall keys as a JSON array. On 64-bit
+// machines, 32-bit programs are listed under the 'WOW6432Node' key.
+const windowsPackagesScript = `$ErrorActionPreference = 'Stop'
+$ProgressPreference = 'SilentlyContinue'
+$keys = @(
+    @{ path = 'HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\*'; arch = [string]$env:PROCESSOR_ARCHITECTURE },
+    @{ path = 'HKLM:\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\*'; arch = 'x86' }
+)
+$packages = @()
+foreach ($key in $keys) {
+    if (-not (Test-Path -Path $key.path)) {
+        continue
+    }
+    Get-ItemProperty -Path $key.path -ErrorAction SilentlyContinue | Where-Object { $_.DisplayName } | ForEach-Object {
+        $packages += [pscustomobject]@{
+            name = [string]$_.DisplayName
+            version = [string]$_.DisplayVersion
+            arch = $key.arch
+            source_repo = [string]$_.Publisher
+        }
+    }
+}
+ConvertTo-Json -Compress -InputObject @($packages)
+`
+
+// PackageInventory is the list of packages installed on a machine.
+type PackageInventory struct {
+	// PackageManager is the package manager that was queried. It is
+	// one of 'rpm', 'dpkg', 'apk', 'pacman', 'brew', 'pkg', 'pkg_info',
+	// or 'windows'.
+	PackageManager string    `json:"package_manager"`
+	Packages       []Package `json:"packages"`
+}
+
+// Package is an installed package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+
+	// SourceRepo is the repository the package was installed from,
+	// if the package manager records it. For brew, this is the tap.
+	// For Windows programs, this is the publisher.
+	SourceRepo string `json:"source_repo,omitempty"`
+}
+
+// packageManager returns the package manager used by an operating
+// system, or an empty string if it is unknown. The os-release 'ID_LIKE'
+// field is used for distributions that are not known by name.
+func packageManager(osName string, osRelease map[string]string) string {
+	names := append([]string{osName}, strings.Fields(osRelease["ID_LIKE"])...)
+
+	for _, name := range names {
+		switch name {
+		case "redhat", "rhel", "centos", "fedora", "rocky", "alma", "oracle", "amazon",
+			"opensuse", "suse", "sles":
+			return rpmPackageManager
+		case "debian", "ubuntu":
+			return dpkgPackageManager
+		case "alpine":
+			return apkPackageManager
+		case "arch":
+			return pacmanPackageManager
+		case "macos":
+			return brewPackageManager
+		case "freebsd", "dragonfly":
+			return pkgPackageManager
+		case "openbsd", "netbsd":
+			return pkgInfoPackageManager
+		case "windows":
+			return windowsPackageManager
+		}
+	}
+
+	return ""
+}
+
+// capturePackages queries the guest's package manager for the list of
+// installed packages.
+func capturePackages(ctx context.Context, c packer.Communicator, osName string, osRelease map[string]string) (*PackageInventory, error) {
+	manager := packageManager(osName, osRelease)
+
+	var packages []Package
+	var err error
+
+	switch manager {
+	case rpmPackageManager:
+		packages, err = rpmPackages(ctx, c)
+	case dpkgPackageManager:
+		packages, err = dpkgPackages(ctx, c)
+	case apkPackageManager:
+		packages, err = apkPackages(ctx, c)
+	case pacmanPackageManager:
+		packages, err = pacmanPackages(ctx, c)
+	case brewPackageManager:
+		packages, err = brewPackages(ctx, c)
+	case pkgPackageManager:
+		packages, err = pkgPackages(ctx, c)
+	case pkgInfoPackageManager:
+		packages, err = pkgInfoPackages(ctx, c)
+	case windowsPackageManager:
+		packages, err = windowsPackages(ctx, c)
+	default:
+		return nil, fmt.Errorf("the package manager for operating system '%s' is unknown", osName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s packages - %s", manager, err.Error())
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].Name == packages[j].Name {
+			return packages[i].Arch < packages[j].Arch
+		}
+		return packages[i].Name < packages[j].Name
+	})
+
+	return &PackageInventory{
+		PackageManager: manager,
+		Packages:       packages,
+	}, nil
+}
+
+// packagesOutput runs a command that lists packages, and returns
+// its output.
+func packagesOutput(ctx context.Context, c packer.Communicator, command string) (string, error) {
+	output, ok := remoteOutput(ctx, c, command)
+	if !ok {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("command '%s' failed", command)
+	}
+
+	return output, nil
+}
+
+// rpmPackages lists rpm packages. dnf is preferred because it records
+// the repository each package was installed from.
+func rpmPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, ok := remoteOutput(ctx, c,
+		`dnf repoquery --installed --quiet --queryformat '%{name}\t%{epoch}:%{version}-%{release}\t%{arch}\t%{from_repo}\n'`)
+	if ok {
+		return parseTabSeparatedPackages(output), nil
+	}
+
+	output, err := packagesOutput(ctx, c,
+		`rpm -qa --queryformat '%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\n'`)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTabSeparatedPackages(output), nil
+}
+
+func dpkgPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c,
+		`dpkg-query --show --showformat '${db:Status-Abbrev}\t${Package}\t${Version}\t${Architecture}\n'`)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDpkgPackages(output), nil
+}
+
+func apkPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c, "cat /lib/apk/db/installed")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseApkPackages(output), nil
+}
+
+func pacmanPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c, "LC_ALL=C pacman -Qi")
+	if err != nil {
+		return nil, err
+	}
+
+	// 'pacman -Sl' lists the packages in the sync repositories, and
+	// marks the installed ones. It is only used to find repositories,
+	// so failures are ignored.
+	syncOutput, _ := remoteOutput(ctx, c, "pacman -Sl")
+
+	return parsePacmanPackages(output, syncOutput), nil
+}
+
+func brewPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c, "brew info --json=v2 --installed")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBrewPackages(output)
+}
+
+func pkgPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c, "pkg query '%n %v %q %R'")
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePkgPackages(output), nil
+}
+
+func pkgInfoPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c, "pkg_info")
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePkgInfoPackages(output), nil
+}
+
+func windowsPackages(ctx context.Context, c packer.Communicator) ([]Package, error) {
+	output, err := packagesOutput(ctx, c,
+		"powershell -NoProfile -NonInteractive -EncodedCommand "+encodePowerShellCommand(windowsPackagesScript))
+	if err != nil {
+		return nil, err
+	}
+
+	start := strings.Index(output, "[")
+	if start < 0 {
+		return nil, fmt.Errorf("powershell output does not contain a json array - '%s'", output)
+	}
+
+	var packages []Package
+	err = json.NewDecoder(strings.NewReader(output[start:])).Decode(&packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse windows programs - %s", err.Error())
+	}
+
+	return packages, nil
+}
+
+// parseTabSeparatedPackages parses lines of tab separated name, version,
+// arch, and (optionally) repository fields. Versions with an epoch of 0
+// are trimmed so that rpm and dnf versions look the same.
+func parseTabSeparatedPackages(s string) []Package {
+	var packages []Package
+
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) < 3 || len(fields[0]) == 0 {
+			continue
+		}
+
+		p := Package{
+			Name:    fields[0],
+			Version: strings.TrimPrefix(fields[1], "0:"),
+			Arch:    fields[2],
+		}
+
+		if len(fields) > 3 {
+			p.SourceRepo = fields[3]
+		}
+
+		packages = append(packages, p)
+	}
+
+	return packages
+}
+
+// parseDpkgPackages parses the output of 'dpkg-query'. Only packages
+// whose status is 'installed' are included.
+func parseDpkgPackages(s string) []Package {
+	var packages []Package
+
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "ii") {
+			continue
+		}
+
+		packages = append(packages, Package{
+			Name:    fields[1],
+			Version: fields[2],
+			Arch:    fields[3],
+		})
+	}
+
+	return packages
+}
+
+// parseApkPackages parses apk's installed database, which is made up
+// of records separated by blank lines. Each line of a record is a
+// single letter key followed by a colon and a value.
+func parseApkPackages(s string) []Package {
+	var packages []Package
+	var current Package
+
+	flush := func() {
+		if len(current.Name) > 0 {
+			packages = append(packages, current)
+		}
+		current = Package{}
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) == 0 {
+			flush()
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		switch line[0] {
+		case 'P':
+			current.Name = line[2:]
+		case 'V':
+			current.Version = line[2:]
+		case 'A':
+			current.Arch = line[2:]
+		}
+	}
+
+	flush()
+
+	return packages
+}
+
+// parsePacmanPackages parses the output of 'pacman -Qi'. The output of
+// 'pacman -Sl' is used to find each package's repository.
+func parsePacmanPackages(s string, syncOutput string) []Package {
+	repos := make(map[string]string)
+
+	for _, line := range strings.Split(syncOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && strings.HasPrefix(fields[3], "[installed") {
+			repos[fields[1]] = fields[0]
+		}
+	}
+
+	var packages []Package
+	var current Package
+
+	flush := func() {
+		if len(current.Name) > 0 {
+			current.SourceRepo = repos[current.Name]
+			packages = append(packages, current)
+		}
+		current = Package{}
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			flush()
+			continue
+		}
+
+		colonIndex := strings.Index(line, ":")
+		if colonIndex < 0 {
+			continue
+		}
+
+		value := strings.TrimSpace(line[colonIndex+1:])
+
+		switch strings.TrimSpace(line[:colonIndex]) {
+		case "Name":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Arch = value
+		}
+	}
+
+	flush()
+
+	return packages
+}
+
+// parseBrewPackages parses the output of 'brew info --json=v2 --installed'.
+func parseBrewPackages(s string) ([]Package, error) {
+	var info struct {
+		Formulae []struct {
+			Name      string `json:"name"`
+			Tap       string `json:"tap"`
+			Installed []struct {
+				Version string `json:"version"`
+			} `json:"installed"`
+		} `json:"formulae"`
+		Casks []struct {
+			Token     string `json:"token"`
+			Tap       string `json:"tap"`
+			Installed string `json:"installed"`
+		} `json:"casks"`
+	}
+
+	err := json.Unmarshal([]byte(s), &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse brew info - %s", err.Error())
+	}
+
+	var packages []Package
+
+	for _, formula := range info.Formulae {
+		for _, installed := range formula.Installed {
+			packages = append(packages, Package{
+				Name:       formula.Name,
+				Version:    installed.Version,
+				SourceRepo: formula.Tap,
+			})
+		}
+	}
+
+	for _, cask := range info.Casks {
+		packages = append(packages, Package{
+			Name:       cask.Token,
+			Version:    cask.Installed,
+			SourceRepo: cask.Tap,
+		})
+	}
+
+	return packages, nil
+}
+
+// parsePkgPackages parses the output of FreeBSD's 'pkg query'. Each
+// line contains a package's name, version, ABI (e.g., 'FreeBSD:14:amd64'),
+// and repository. The architecture is the last element of the ABI.
+func parsePkgPackages(s string) []Package {
+	var packages []Package
+
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		p := Package{
+			Name:    fields[0],
+			Version: fields[1],
+		}
+
+		if len(fields) > 2 {
+			abi := strings.Split(fields[2], ":")
+			p.Arch = abi[len(abi)-1]
+		}
+
+		if len(fields) > 3 {
+			p.SourceRepo = fields[3]
+		}
+
+		packages = append(packages, p)
+	}
+
+	return packages
+}
+
+// parsePkgInfoPackages parses the output of OpenBSD and NetBSD's
+// 'pkg_info'. Each line starts with the package's name and version
+// (e.g., 'vim-9.0.2073-no_x11'), followed by its description. The
+// version starts after the first '-' that is followed by a digit.
+func parsePkgInfoPackages(s string) []Package {
+	var packages []Package
+
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		p := Package{
+			Name: fields[0],
+		}
+
+		for i := 0; i < len(fields[0])-1; i++ {
+			if fields[0][i] == '-' && fields[0][i+1] >= '0' && fields[0][i+1] <= '9' {
+				p.Name = fields[0][:i]
+				p.Version = fields[0][i+1:]
+				break
+			}
+		}
+
+		packages = append(packages, p)
+	}
+
+	return packages
+}
@@ -12,12 +12,6 @@ const (
 	endPackerVariable    = "}}"
 )
 
-var (
-	newLineBytes             = []byte{'\n'}
-	endPackerVariableBytes   = []byte(endPackerVariable)
-	startPackerVariableBytes = []byte(startPackerVariable)
-)
-
 type packerVariable string
 
 const (
@@ -130,12 +124,14 @@ func packerVariableTypeAndName(body string) (packerVariable, string) {
 }
 
 func trimVariableStringToFile(str string) (dir string, name string, err error) {
-	lastBraceIndex := strings.LastIndex(str, endPackerVariable)
+	// Both packer variables ('}}') and HCL2 variables ('}') end
+	// with a closing brace.
+	lastBraceIndex := strings.LastIndex(str, hcl2VariableEnd)
 	if lastBraceIndex < 0 {
 		return "", "", fmt.Errorf("'%s' does not contain a packer variable", str)
 	}
 
-	str = str[lastBraceIndex+len(endPackerVariable):]
+	str = str[lastBraceIndex+len(hcl2VariableEnd):]
 
 	return filepath.Dir(str), filepath.Base(str), nil
 }
@@ -0,0 +1,241 @@
+package breadcrumbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ProvenanceFileName is the name of the provenance attestation
+	// stored in the breadcrumbs directory when 'provenance' is enabled.
+	// It is written after the manifest, and its subject is the manifest.
+	ProvenanceFileName = "provenance.intoto.json"
+
+	inTotoStatementType    = "https://in-toto.io/Statement/v0.1"
+	slsaProvenanceType     = "https://slsa.dev/provenance/v0.2"
+	provenanceBuildType    = "https://github.com/stephen-fox/packer-breadcrumbs/provenance/v1"
+	provenanceBuilderIdFmt = "https://www.packer.io/docs/builders/%s"
+
+	// treeHashDigestName is the digest algorithm name of a project
+	// directory's tree hash, which is not a standard algorithm.
+	treeHashDigestName = "breadcrumbs_tree_sha256"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	Builder    slsaBuilder    `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation slsaInvocation `json:"invocation"`
+	Metadata   slsaMetadata   `json:"metadata"`
+	Materials  []slsaMaterial `json:"materials"`
+}
+
+type slsaBuilder struct {
+	Id string `json:"id"`
+}
+
+type slsaInvocation struct {
+	ConfigSource slsaConfigSource     `json:"configSource"`
+	Parameters   slsaInvocationParams `json:"parameters"`
+}
+
+type slsaConfigSource struct {
+	Uri        string            `json:"uri"`
+	Digest     map[string]string `json:"digest"`
+	EntryPoint string            `json:"entryPoint"`
+}
+
+// slsaInvocationParams are the build's parameters. The template files
+// are included so that a consumer can verify the template that was
+// used without trusting the configuration source.
+type slsaInvocationParams struct {
+	PackerBuildName string            `json:"packer_build_name"`
+	PackerBuildType string            `json:"packer_build_type"`
+	PackerUserVars  map[string]string `json:"packer_user_variables"`
+	TemplateFormat  TemplateFormat    `json:"packer_template_format"`
+	TemplateFiles   []slsaMaterial    `json:"packer_template_files"`
+}
+
+type slsaMetadata struct {
+	BuildFinishedOn string           `json:"buildFinishedOn"`
+	Completeness    slsaCompleteness `json:"completeness"`
+	Reproducible    bool             `json:"reproducible"`
+}
+
+type slsaCompleteness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+type slsaMaterial struct {
+	Uri    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// newProvenance creates an in-toto statement with a SLSA provenance
+// predicate. The subject is the manifest, which in turn records the
+// hashes of every other file in the breadcrumbs directory.
+func newProvenance(manifest *Manifest, manifestJson []byte, now time.Time) ([]byte, error) {
+	source := sourceMaterial(manifest)
+
+	var templates []slsaMaterial
+	for _, t := range manifest.TemplateFiles {
+		templates = append(templates, slsaMaterial{
+			Uri:    t.Name,
+			Digest: fileMetaDigest(t),
+		})
+	}
+
+	entryPoint := "."
+	if len(manifest.TemplateFiles) == 1 {
+		entryPoint = manifest.TemplateFiles[0].Name
+	}
+
+	materials := []slsaMaterial{source}
+
+	for _, f := range manifest.FoundFiles {
+		materials = append(materials, slsaMaterial{
+			Uri:    f.FoundAtPath,
+			Digest: fileMetaDigest(f),
+		})
+	}
+
+	statement := inTotoStatement{
+		Type: inTotoStatementType,
+		Subject: []inTotoSubject{
+			{
+				Name:   ManifestFileName,
+				Digest: map[string]string{"sha256": hashBytes(manifestJson)},
+			},
+		},
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			Builder: slsaBuilder{
+				Id: fmt.Sprintf(provenanceBuilderIdFmt, url.PathEscape(manifest.PackerBuildType)),
+			},
+			BuildType: provenanceBuildType,
+			Invocation: slsaInvocation{
+				ConfigSource: slsaConfigSource{
+					Uri:        source.Uri,
+					Digest:     source.Digest,
+					EntryPoint: entryPoint,
+				},
+				Parameters: slsaInvocationParams{
+					PackerBuildName: manifest.PackerBuildName,
+					PackerBuildType: manifest.PackerBuildType,
+					PackerUserVars:  manifest.PackerUserVars,
+					TemplateFormat:  manifest.TemplateFormat,
+					TemplateFiles:   templates,
+				},
+			},
+			Metadata: slsaMetadata{
+				BuildFinishedOn: now.UTC().Format(time.RFC3339),
+				Completeness: slsaCompleteness{
+					Parameters: true,
+				},
+			},
+			Materials: materials,
+		},
+	}
+
+	raw, err := json.MarshalIndent(statement, jsonPrefix, jsonIndent)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, '\n'), nil
+}
+
+// sourceMaterial returns the material describing the project's
+// repository at the recorded revision. Repositories are identified by
+// their SPDX style download location (e.g., 'git+<url>'). Projects that
+// are not in a repository are identified by the project directory's
+// tree hash.
+func sourceMaterial(manifest *Manifest) slsaMaterial {
+	switch manifest.VCS {
+	case MercurialVcs:
+		var remote, revision string
+		if manifest.Mercurial != nil {
+			remote = manifest.Mercurial.Remote
+			revision = manifest.Mercurial.Revision
+		}
+
+		return slsaMaterial{
+			Uri:    vcsMaterialUri("hg", remote, manifest.projectDirPath),
+			Digest: map[string]string{"sha1": revision},
+		}
+	case NoVcs:
+		return slsaMaterial{
+			Uri:    fileUri(manifest.projectDirPath),
+			Digest: map[string]string{treeHashDigestName: manifest.TreeHash},
+		}
+	}
+
+	var remote string
+	if manifest.Git != nil {
+		remote = manifest.Git.Remote
+	}
+
+	return slsaMaterial{
+		Uri:    vcsMaterialUri("git", remote, manifest.projectDirPath),
+		Digest: map[string]string{"sha1": manifest.GitRevision},
+	}
+}
+
+// vcsMaterialUri returns '<vcs>+<remote>', or '<vcs>+<file URI of the
+// project directory>' if the repository does not have a remote.
+func vcsMaterialUri(vcs string, remote string, dirPath string) string {
+	if len(remote) > 0 {
+		return vcs + "+" + remote
+	}
+
+	return vcs + "+" + fileUri(dirPath)
+}
+
+func fileUri(filePath string) string {
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(filePath),
+	}
+
+	// Windows paths start with a drive letter rather than a slash.
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	return u.String()
+}
+
+func fileMetaDigest(meta FileMeta) map[string]string {
+	digest := make(map[string]string)
+
+	if len(meta.Sha256) > 0 {
+		digest["sha256"] = meta.Sha256
+	}
+
+	if len(meta.Sha512) > 0 {
+		digest["sha512"] = meta.Sha512
+	}
+
+	if len(digest) == 0 {
+		return nil
+	}
+
+	return digest
+}
@@ -1,6 +1,7 @@
 package breadcrumbs
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,12 +13,14 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/packer/common"
 	"github.com/hashicorp/packer/helper/config"
 	"github.com/hashicorp/packer/packer"
+	"github.com/hashicorp/packer/template/interpolate"
 )
 
 const (
@@ -27,9 +30,7 @@ const (
 	jsonIndent                     = "    "
 	httpFilePrefix                 = "http://"
 	httpsFilePrefix                = "https://"
-
-	doubleQuoteChar byte = '"'
-	possibleDelims       = "'\" "
+	guestCleanupTimeout            = 30 * time.Second
 )
 
 type FileSource string
@@ -39,20 +40,59 @@ const (
 	LocalStorage FileSource = "local_storage"
 	HttpHost     FileSource = "http_host"
 	HttpsHost    FileSource = "https_host"
+	Generated    FileSource = "generated"
 )
 
 type FileMeta struct {
-	Name         string     `json:"name"`
-	FoundAtPath  string     `json:"found_at_path"`
-	StoredAtPath string     `json:"stored_at_path"`
-	Source       FileSource `json:"source"`
-	unresolved   bool       `json:"-"`
+	Name             string     `json:"name"`
+	FoundAtPath      string     `json:"found_at_path"`
+	ResolvedPath     string     `json:"resolved_path,omitempty"`
+	StoredAtPath     string     `json:"stored_at_path"`
+	Source           FileSource `json:"source"`
+	FoundInTemplate  string     `json:"found_in_template"`
+	JsonPointer      string     `json:"json_pointer"`
+	SizeBytes        int64      `json:"size_bytes"`
+	Sha1             string     `json:"sha1,omitempty"`
+	Sha256           string     `json:"sha256"`
+	Sha512           string     `json:"sha512,omitempty"`
+	ModTime          string     `json:"mod_time,omitempty"`
+	HttpLastModified string     `json:"http_last_modified,omitempty"`
+	HttpETag         string     `json:"http_etag,omitempty"`
+	unresolved       bool       `json:"-"`
+
+	// sourcePath is the location that the file is fetched from if
+	// FoundAtPath was redacted.
+	sourcePath string `json:"-"`
+}
+
+// location returns the unredacted location of the file.
+func (o FileMeta) location() string {
+	if len(o.sourcePath) > 0 {
+		return o.sourcePath
+	}
+
+	return o.FoundAtPath
 }
 
 func (o FileMeta) DestinationDirPath(rootDirPath string) string {
 	return path.Dir(filepath.Join(rootDirPath, o.StoredAtPath))
 }
 
+func (o *FileMeta) setSaved(saved savedFile) {
+	o.SizeBytes = saved.sizeBytes
+	o.Sha1 = saved.sha1
+	o.Sha256 = saved.sha256
+	o.Sha512 = saved.sha512
+	o.HttpLastModified = saved.lastModified
+	o.HttpETag = saved.etag
+
+	if !saved.modTime.IsZero() {
+		o.ModTime = saved.modTime.UTC().Format(time.RFC3339)
+	}
+}
+
+//go:generate mapstructure-to-hcl2 -type PluginConfig,HttpAuthRule
+
 type PluginConfig struct {
 	// The following line embeds the 'common.PackerConfig', which is
 	// provided by Packer during the 'Prepare()' call. This allows
@@ -64,42 +104,153 @@ type PluginConfig struct {
 	// 'common.PackerConfig' struct.
 	TemplatePath string `mapstructure:"packer_template_path"`
 
-	IncludeSuffixes   []string `mapstructure:"include_suffixes"`
-	ArtifactsDirPath  string   `mapstructure:"artifacts_dir_path"`
-	UploadDirPath     string   `mapstructure:"upload_dir_path"`
-	TemplateSizeBytes int64    `mapstructure:"template_size_bytes"`
-	SaveFileSizeBytes int64    `mapstructure:"save_file_size_bytes"`
-	DebugConfig       bool     `mapstructure:"debug_config"`
-	DebugManifest     bool     `mapstructure:"debug_manifest"`
-	DebugBreadcrumbs  bool     `mapstructure:"debug_breadcrumbs"`
+	IncludeSuffixes    []string `mapstructure:"include_suffixes"`
+	ArtifactsDirPath   string   `mapstructure:"artifacts_dir_path"`
+	UploadDirPath      string   `mapstructure:"upload_dir_path"`
+	TemplateSizeBytes  int64    `mapstructure:"template_size_bytes"`
+	SaveFileSizeBytes  int64    `mapstructure:"save_file_size_bytes"`
+	HashSha512         bool     `mapstructure:"hash_sha512"`
+	CapturePackages    bool     `mapstructure:"capture_packages"`
+	SbomFormats        []string `mapstructure:"sbom_formats"`
+	SbomHostDirPath    string   `mapstructure:"sbom_host_dir_path"`
+	Provenance         bool     `mapstructure:"provenance"`
+	SigningKeyPath     string   `mapstructure:"signing_key_path"`
+	SaveGitDiff        bool     `mapstructure:"save_git_diff"`
+	AllowNoVcs         bool     `mapstructure:"allow_no_vcs"`
+	MaxParallelFetches int      `mapstructure:"max_parallel_fetches"`
+
+	TreeHashExcludePatterns []string `mapstructure:"tree_hash_exclude_patterns"`
+
+	HttpAttempts         int               `mapstructure:"http_attempts"`
+	HttpRetryStatusCodes []int             `mapstructure:"http_retry_status_codes"`
+	HttpRetryBackoff     string            `mapstructure:"http_retry_backoff"`
+	HttpRetryMaxBackoff  string            `mapstructure:"http_retry_max_backoff"`
+	HttpTimeout          string            `mapstructure:"http_timeout"`
+	HttpHostTimeouts     map[string]string `mapstructure:"http_host_timeouts"`
+	HttpDeadline         string            `mapstructure:"http_deadline"`
+	HttpAuth             []HttpAuthRule    `mapstructure:"http_auth"`
+	HttpCaBundlePath     string            `mapstructure:"http_ca_bundle_path"`
+	HttpClientCertPath   string            `mapstructure:"http_client_cert_path"`
+	HttpClientKeyPath    string            `mapstructure:"http_client_key_path"`
+	HttpProxy            string            `mapstructure:"http_proxy"`
+	HttpsProxy           string            `mapstructure:"https_proxy"`
+	NoProxy              string            `mapstructure:"no_proxy"`
+	Mirrors              map[string]string `mapstructure:"mirrors"`
+
+	RedactVariablePatterns []string `mapstructure:"redact_variable_patterns"`
+	RedactValuePatterns    []string `mapstructure:"redact_value_patterns"`
+	RedactSecretValues     bool     `mapstructure:"redact_secret_values"`
+	RedactionMode          string   `mapstructure:"redaction_mode"`
+	RedactionSalt          string   `mapstructure:"redaction_salt"`
+
+	DebugConfig      bool `mapstructure:"debug_config"`
+	DebugManifest    bool `mapstructure:"debug_manifest"`
+	DebugBreadcrumbs bool `mapstructure:"debug_breadcrumbs"`
 
 	ProjectDirPath string `mapstructure:"-"`
 	PluginVersion  string `mapstructure:"-"`
+
+	ctx interpolate.Context
 }
 
 type Provisioner struct {
 	Config PluginConfig
+
+	cancelLock sync.Mutex
+	cancel     context.CancelFunc
 }
 
 func (o *Provisioner) ConfigSpec() hcldec.ObjectSpec {
-	return nil
+	return o.Config.FlatMapstructure().HCL2Spec()
 }
 
 func (o *Provisioner) Prepare(rawConfigs ...interface{}) error {
-	// TODO: Interpolate user variables.
-	err := config.Decode(&o.Config, nil, rawConfigs...)
+	o.Config.ctx.EnableEnv = true
+
+	err := config.Decode(&o.Config, &config.DecodeOpts{
+		Interpolate:        true,
+		InterpolateContext: &o.Config.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			Exclude: []string{},
+		},
+	}, rawConfigs...)
 	if err != nil {
 		return err
 	}
 
+	var errs *packer.MultiError
+
 	if len(strings.TrimSpace(o.Config.TemplatePath)) == 0 {
-		return fmt.Errorf("failed to get packer template path")
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("failed to get packer template path"))
+	} else if info, err := os.Stat(o.Config.TemplatePath); err != nil {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("failed to stat packer template path - %s", err.Error()))
+	} else if info.IsDir() {
+		o.Config.ProjectDirPath = o.Config.TemplatePath
+	} else {
+		o.Config.ProjectDirPath = filepath.Dir(o.Config.TemplatePath)
 	}
 
-	o.Config.ProjectDirPath = filepath.Dir(o.Config.TemplatePath)
+	if o.Config.TemplateSizeBytes < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("template_size_bytes cannot be negative"))
+	}
+
+	if o.Config.SaveFileSizeBytes < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("save_file_size_bytes cannot be negative"))
+	}
+
+	if o.Config.MaxParallelFetches < 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("max_parallel_fetches cannot be negative"))
+	}
+
+	_, err = newHttpRetryPolicy(&o.Config)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
 
-	if len(strings.TrimSpace(o.Config.UploadDirPath)) == 0 {
-		o.Config.UploadDirPath = "/"
+	_, err = newHttpTransport(&o.Config)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+
+	_, err = newMirrorMap(&o.Config)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+
+	for _, format := range o.Config.SbomFormats {
+		if len(sbomFileName(format)) == 0 {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("sbom format '%s' is not supported - must be '%s' or '%s'",
+				format, CycloneDxSbomFormat, SpdxSbomFormat))
+		}
+	}
+
+	if len(o.Config.SbomFormats) > 0 && len(strings.TrimSpace(o.Config.SbomHostDirPath)) == 0 &&
+		len(strings.TrimSpace(o.Config.ArtifactsDirPath)) == 0 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("sbom_host_dir_path or artifacts_dir_path must be set when sbom_formats is set"))
+	}
+
+	for _, pattern := range o.Config.TreeHashExcludePatterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("failed to parse tree hash exclude pattern '%s' - %s",
+				pattern, err.Error()))
+		}
+	}
+
+	_, err = newRedactor(&o.Config)
+	if err != nil {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+
+	if len(o.Config.SigningKeyPath) > 0 {
+		_, err := loadSigningKey(o.Config.SigningKeyPath)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		}
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
 	}
 
 	if o.Config.TemplateSizeBytes == 0 {
@@ -110,6 +261,16 @@ func (o *Provisioner) Prepare(rawConfigs ...interface{}) error {
 		o.Config.SaveFileSizeBytes = defaultSaveFileSizeBytes
 	}
 
+	if o.Config.MaxParallelFetches == 0 {
+		o.Config.MaxParallelFetches = defaultMaxParallelFetches
+	}
+
+	// HCL2 decodes a missing block list as an empty
+	// slice rather than nil.
+	if len(o.Config.HttpAuth) == 0 {
+		o.Config.HttpAuth = nil
+	}
+
 	if o.Config.DebugConfig {
 		debugRaw, _ := json.MarshalIndent(o.Config, jsonPrefix, jsonIndent)
 
@@ -143,7 +304,13 @@ func (o *Provisioner) Prepare(rawConfigs ...interface{}) error {
 			}
 		}
 
-		err = createBreadcrumbs(o.Config.ArtifactsDirPath, manifest, o.Config.SaveFileSizeBytes)
+		ui := &packer.BasicUi{
+			Reader:      os.Stdin,
+			Writer:      os.Stdout,
+			ErrorWriter: os.Stderr,
+		}
+
+		err = createBreadcrumbs(context.Background(), ui, o.Config.ArtifactsDirPath, manifest, &o.Config)
 		if err != nil {
 			return err
 		}
@@ -154,58 +321,131 @@ func (o *Provisioner) Prepare(rawConfigs ...interface{}) error {
 	return nil
 }
 
-func (o *Provisioner) Provision(_ context.Context, ui packer.Ui, communicator packer.Communicator, _ map[string]interface{}) error {
-	var optionalFields OptionalManifestFields
+func (o *Provisioner) Provision(ctx context.Context, ui packer.Ui, communicator packer.Communicator, _ map[string]interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	switch getOSCategory(communicator) {
-	case unix:
-		var ok bool
-		optionalFields.OSName, optionalFields.OSVersion, ok = isRedHat(communicator)
-		if ok {
-			break
-		}
-		optionalFields.OSName, optionalFields.OSVersion, ok = isDebian(communicator)
-		if ok {
-			break
+	o.cancelLock.Lock()
+	o.cancel = cancel
+	o.cancelLock.Unlock()
+
+	err := o.provision(ctx, ui, communicator)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("breadcrumbs provisioning was cancelled - %s", ctx.Err().Error())
+	}
+
+	return err
+}
+
+func (o *Provisioner) provision(ctx context.Context, ui packer.Ui, communicator packer.Communicator) error {
+	category := getOSCategory(ctx, communicator)
+
+	optionalFields := detectOS(ctx, communicator, category)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	manifest, err := newManifest(&o.Config, optionalFields)
+	if err != nil {
+		return err
+	}
+
+	if o.Config.CapturePackages {
+		if len(packageManager(manifest.OSName, manifest.OSRelease)) == 0 {
+			ui.Error(fmt.Sprintf("Warning: the package manager for operating system '%s' is unknown - installed packages will not be recorded",
+				manifest.OSName))
+		} else {
+			ui.Say("Capturing installed packages...")
+
+			manifest.packages, err = capturePackages(ctx, communicator, manifest.OSName, manifest.OSRelease)
+			if err != nil {
+				return fmt.Errorf("failed to capture installed packages - %s", err.Error())
+			}
 		}
-		optionalFields.OSName, optionalFields.OSVersion, ok = isMacos(communicator)
-		if ok {
-			break
+	}
+
+	artifactsDirPath := o.Config.ArtifactsDirPath
+	if len(strings.TrimSpace(artifactsDirPath)) == 0 {
+		artifactsDirPath, err = ioutil.TempDir("", "breadcrumbs-")
+		if err != nil {
+			return err
 		}
-	case windows:
-		optionalFields.OSName = "windows"
-		optionalFields.OSVersion = windowsVersion(communicator)
+		defer os.RemoveAll(artifactsDirPath)
 	}
 
-	manifest, err := newManifest(&o.Config, optionalFields)
+	err = createBreadcrumbs(ctx, ui, artifactsDirPath, manifest, &o.Config)
 	if err != nil {
 		return err
 	}
 
-	if len(strings.TrimSpace(o.Config.ArtifactsDirPath)) == 0 {
-		temp, err := ioutil.TempDir("", "breadcrumbs-")
+	// The SBOMs are not copied into the project directory by default
+	// because they would show up as untracked files in later builds.
+	// If 'sbom_host_dir_path' is not set, then the artifacts directory
+	// (which already contains them) must be.
+	if len(manifest.SbomFiles) > 0 && len(strings.TrimSpace(o.Config.SbomHostDirPath)) > 0 {
+		err = copySbomFiles(artifactsDirPath, o.Config.SbomHostDirPath, manifest)
 		if err != nil {
 			return err
 		}
 
-		o.Config.ArtifactsDirPath = filepath.Join(temp, "breadcrumbs")
+		ui.Say(fmt.Sprintf("Saved SBOM(s) to '%s'", o.Config.SbomHostDirPath))
+	}
+
+	uploadDirPath := o.Config.UploadDirPath
+	if len(strings.TrimSpace(uploadDirPath)) == 0 {
+		uploadDirPath = defaultUploadDirPath(category)
+	}
+	uploadDirPath = normalizeGuestPath(category, uploadDirPath)
 
-		err = os.MkdirAll(o.Config.ArtifactsDirPath, 0700)
+	ui.Say(fmt.Sprintf("Uploading breadcrumbs to '%s'...", uploadDirPath))
+
+	exists, err := guestDirExists(ctx, communicator, category, uploadDirPath)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		err = createGuestDir(ctx, communicator, category, uploadDirPath)
 		if err != nil {
 			return err
 		}
-		defer os.RemoveAll(o.Config.ArtifactsDirPath)
 	}
 
-	err = createBreadcrumbs(o.Config.ArtifactsDirPath, manifest, o.Config.SaveFileSizeBytes)
+	// The breadcrumbs are uploaded to a new directory, and then moved
+	// into the upload directory. That way, a failed upload can be
+	// cleaned up without removing files that the plugin did not create.
+	stagingDirPath, err := newGuestStagingDirPath(category, uploadDirPath)
 	if err != nil {
 		return err
 	}
 
-	ui.Say(fmt.Sprintf("Uploading breadcrumbs to '%s'...", o.Config.UploadDirPath))
+	err = createGuestDir(ctx, communicator, category, stagingDirPath)
+	if err != nil {
+		return err
+	}
 
-	err = communicator.UploadDir("/", o.Config.ArtifactsDirPath, nil)
+	err = uploadDir(ctx, communicator, stagingDirPath, artifactsDirPath)
+	if err == nil {
+		err = moveGuestDirContents(ctx, communicator, category, stagingDirPath, uploadDirPath)
+	}
 	if err != nil {
+		// The upload directory is only removed if it was created
+		// by the plugin. The context may be done at this point,
+		// so a new one is used for the cleanup.
+		cleanupDirPath := stagingDirPath
+		if !exists {
+			cleanupDirPath = uploadDirPath
+		}
+
+		cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), guestCleanupTimeout)
+		defer cancelCleanup()
+
+		cleanupErr := removeGuestDir(cleanupCtx, communicator, category, cleanupDirPath)
+		if cleanupErr != nil {
+			ui.Error(fmt.Sprintf("Failed to clean up partially uploaded breadcrumbs - %s", cleanupErr.Error()))
+		}
+
 		return err
 	}
 
@@ -215,127 +455,318 @@ func (o *Provisioner) Provision(_ context.Context, ui packer.Ui, communicator pa
 }
 
 func (o *Provisioner) Cancel() {
-	// TODO: Something a little more elegant than this.
-	os.Exit(123)
+	o.cancelLock.Lock()
+	defer o.cancelLock.Unlock()
+
+	if o.cancel != nil {
+		o.cancel()
+	}
 }
 
-func createBreadcrumbs(rootDirPath string, manifest *Manifest, maxSaveSizeBytes int64) error {
+// uploadDir uploads the contents of a local directory to the guest. The
+// communicator does not accept a context, so the upload is abandoned
+// (rather than stopped) if the context is done first.
+func uploadDir(ctx context.Context, c packer.Communicator, guestDirPath string, localDirPath string) error {
+	uploadErr := make(chan error, 1)
+
+	go func() {
+		// The trailing slash tells the communicator to upload the
+		// contents of the directory rather than the directory itself.
+		uploadErr <- c.UploadDir(guestDirPath, localDirPath+"/", nil)
+	}()
+
+	select {
+	case err := <-uploadErr:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func createBreadcrumbs(ctx context.Context, ui packer.Ui, rootDirPath string, manifest *Manifest, config *PluginConfig) error {
 	err := os.MkdirAll(rootDirPath, 0700)
 	if err != nil {
 		return err
 	}
 
-	manifestJson, err := manifest.ToJson()
+	for i := range manifest.TemplateFiles {
+		storedAtPath := manifest.TemplateFiles[i].StoredAtPath
+		raw := manifest.templatesRaw[storedAtPath]
+
+		dest, err := os.OpenFile(path.Join(rootDirPath, storedAtPath), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+
+		saved, err := saveHashed(dest, bytes.NewReader(raw), int64(len(raw)), config.HashSha512)
+		dest.Close()
+		if err != nil {
+			return err
+		}
+
+		manifest.TemplateFiles[i].setSaved(saved)
+	}
+
+	err = fetchFoundFiles(ctx, ui, rootDirPath, manifest, config)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(path.Join(rootDirPath, "breadcrumbs.json"), manifestJson, 0600)
+	// Generated files are recreated each time so that they
+	// are not recorded twice.
+	manifest.GeneratedFiles = nil
+	manifest.PackagesFile = ""
+
+	if manifest.packages != nil {
+		raw, err := json.MarshalIndent(manifest.packages, jsonPrefix, jsonIndent)
+		if err != nil {
+			return err
+		}
+
+		err = saveGeneratedFile(rootDirPath, PackagesFileName, append(raw, '\n'), manifest, config)
+		if err != nil {
+			return err
+		}
+
+		manifest.PackagesFile = PackagesFileName
+	}
+
+	if manifest.Git != nil {
+		manifest.Git.DiffFile = ""
+
+		if manifest.gitDiff != nil {
+			err := saveGeneratedFile(rootDirPath, GitDiffFileName, manifest.gitDiff, manifest, config)
+			if err != nil {
+				return err
+			}
+
+			manifest.Git.DiffFile = GitDiffFileName
+		}
+	}
+
+	manifest.SbomFiles = nil
+
+	for _, format := range config.SbomFormats {
+		raw, err := newSbom(format, manifest, time.Now())
+		if err != nil {
+			return err
+		}
+
+		name := sbomFileName(format)
+
+		err = saveGeneratedFile(rootDirPath, name, raw, manifest, config)
+		if err != nil {
+			return err
+		}
+
+		manifest.SbomFiles = append(manifest.SbomFiles, name)
+	}
+
+	// The manifest is written last so that it includes the
+	// hashes of the files that were saved.
+	manifestJson, err := manifest.ToJson()
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(path.Join(rootDirPath, manifest.PackerTemplate), manifest.pTemplateRaw, 0600)
+	err = ioutil.WriteFile(path.Join(rootDirPath, ManifestFileName), manifestJson, 0600)
 	if err != nil {
 		return err
 	}
 
-	for i := range manifest.FoundFiles {
-		destDirPath := manifest.FoundFiles[i].DestinationDirPath(rootDirPath)
-		err := os.MkdirAll(destDirPath, 0700)
+	if len(config.SigningKeyPath) > 0 {
+		key, err := loadSigningKey(config.SigningKeyPath)
 		if err != nil {
 			return err
 		}
 
-		destPath := path.Join(destDirPath, manifest.FoundFiles[i].StoredAtPath)
+		err = ioutil.WriteFile(path.Join(rootDirPath, SignatureFileName), signManifest(key, manifestJson), 0600)
+		if err != nil {
+			return err
+		}
+	}
 
-		switch manifest.FoundFiles[i].Source {
-		case HttpHost, HttpsHost:
-			p, err := url.Parse(manifest.FoundFiles[i].FoundAtPath)
-			if err != nil {
-				return err
-			}
+	if config.Provenance {
+		raw, err := newProvenance(manifest, manifestJson, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to create provenance - %s", err.Error())
+		}
 
-			err = getHttpFile(p, destPath, 0600, maxSaveSizeBytes, 30 * time.Second)
-			if err != nil {
-				return err
-			}
-		case LocalStorage:
-			err := copyLocalFile(manifest.FoundFiles[i].FoundAtPath, destPath, 0600, maxSaveSizeBytes)
-			if err != nil {
-				return fmt.Errorf("failed to copy local file '%s' to '%s' - %s",
-					manifest.FoundFiles[i].FoundAtPath, destPath, err.Error())
-			}
-		default:
-			return fmt.Errorf("unknown file source '%s'", manifest.FoundFiles[i].Source)
+		err = ioutil.WriteFile(path.Join(rootDirPath, ProvenanceFileName), raw, 0600)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func getHttpFile(p *url.URL, destPath string, mode os.FileMode, maxSizeBytes int64, timeout time.Duration) error {
-	dest, err := os.OpenFile(destPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, mode)
+// copySbomFiles copies the SBOM documents in the breadcrumbs directory
+// to a directory on the host. The copies are prefixed with the packer
+// build name so that builds sharing a directory do not overwrite each
+// other's documents.
+func copySbomFiles(rootDirPath string, hostDirPath string, manifest *Manifest) error {
+	err := os.MkdirAll(hostDirPath, 0755)
 	if err != nil {
 		return err
 	}
-	defer dest.Close()
 
-	httpClient := &http.Client{
-		Timeout: timeout,
+	for _, name := range manifest.SbomFiles {
+		raw, err := ioutil.ReadFile(path.Join(rootDirPath, name))
+		if err != nil {
+			return err
+		}
+
+		hostName := name
+		if len(manifest.PackerBuildName) > 0 {
+			hostName = manifest.PackerBuildName + "-" + name
+		}
+
+		err = ioutil.WriteFile(filepath.Join(hostDirPath, hostName), raw, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save sbom to host - %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// saveGeneratedFile saves a file that was generated by the plugin (e.g.,
+// the package list) to the breadcrumbs directory, and records it in
+// the manifest's generated files.
+func saveGeneratedFile(rootDirPath string, name string, raw []byte, manifest *Manifest, config *PluginConfig) error {
+	dest, err := os.OpenFile(path.Join(rootDirPath, name), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
 	}
 
-	response, err := httpClient.Get(p.String())
+	saved, err := saveHashed(dest, bytes.NewReader(raw), int64(len(raw)), config.HashSha512)
+	dest.Close()
 	if err != nil {
 		return err
 	}
 
+	meta := FileMeta{
+		Name:         name,
+		StoredAtPath: name,
+		Source:       Generated,
+	}
+	meta.setSaved(saved)
+
+	manifest.GeneratedFiles = append(manifest.GeneratedFiles, meta)
+
+	return nil
+}
+
+func getHttpFile(ctx context.Context, httpClient *http.Client, p *url.URL, destPath string, mode os.FileMode, maxSizeBytes int64, withSha512 bool) (savedFile, error) {
+	dest, err := os.OpenFile(destPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, mode)
+	if err != nil {
+		return savedFile{}, err
+	}
+	defer dest.Close()
+
+	request, err := http.NewRequest(http.MethodGet, p.String(), nil)
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return savedFile{}, &httpRetryableError{err: err}
+	}
+	defer response.Body.Close()
+
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to GET http file '%s' - got status code %d",
-			p.String(), response.StatusCode)
+		return savedFile{}, &httpStatusError{
+			url:        p.String(),
+			statusCode: response.StatusCode,
+			retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+		}
 	}
 
-	r := io.LimitReader(response.Body, maxSizeBytes)
+	body := &errReader{r: response.Body}
 
-	_, err = io.Copy(dest, r)
-	switch err {
-	case nil:
+	saved, err := saveHashed(dest, body, maxSizeBytes, withSha512)
+	switch {
+	case err == nil:
 		break
-	case io.EOF:
-		return fmt.Errorf("http file '%s' exceeds maximum size of %d byte(s)",
+	case err == errExceedsMaxSize:
+		return savedFile{}, fmt.Errorf("http file '%s' exceeds maximum size of %d byte(s)",
 			p.String(), maxSizeBytes)
+	case body.err != nil:
+		// The connection failed while reading the body.
+		return savedFile{}, &httpRetryableError{err: err}
 	default:
-		return err
+		return savedFile{}, err
 	}
 
-	return nil
+	saved.lastModified = response.Header.Get("Last-Modified")
+	saved.etag = response.Header.Get("ETag")
+
+	return saved, nil
 }
 
-func copyLocalFile(sourcePath string, destPath string, mode os.FileMode, maxSizeBytes int64) error {
+func copyLocalFile(ctx context.Context, sourcePath string, destPath string, mode os.FileMode, maxSizeBytes int64, withSha512 bool) (savedFile, error) {
 	dest, err := os.OpenFile(destPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, mode)
 	if err != nil {
-		return err
+		return savedFile{}, err
 	}
 	defer dest.Close()
 
 	source, err := os.Open(sourcePath)
 	if err != nil {
-		return err
+		return savedFile{}, err
 	}
 	defer source.Close()
 
-	sourceLimiter := io.LimitReader(source, maxSizeBytes)
+	info, err := source.Stat()
+	if err != nil {
+		return savedFile{}, err
+	}
 
-	_, err = io.Copy(dest, sourceLimiter)
+	saved, err := saveHashed(dest, &contextReader{ctx: ctx, r: source}, maxSizeBytes, withSha512)
 	switch err {
 	case nil:
 		break
-	case io.EOF:
-		return fmt.Errorf("local file '%s' exceeds maximum size of %d byte(s)",
+	case errExceedsMaxSize:
+		return savedFile{}, fmt.Errorf("local file '%s' exceeds maximum size of %d byte(s)",
 			sourcePath, maxSizeBytes)
 	default:
-		return err
+		return savedFile{}, err
 	}
 
-	return nil
+	saved.modTime = info.ModTime()
+
+	return saved, nil
+}
+
+// errReader is an io.Reader that records the error returned by r
+// (other than io.EOF).
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (o *errReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+
+	return n, err
+}
+
+// contextReader is an io.Reader that fails once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (o *contextReader) Read(p []byte) (int, error) {
+	err := o.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	return o.r.Read(p)
 }
@@ -0,0 +1,513 @@
+package breadcrumbs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ManifestSchemaVersion is the version of the manifest format
+	// written by this version of the plugin. Manifests written before
+	// the format was versioned have a schema version of 0.
+	ManifestSchemaVersion = 1
+)
+
+// DecodeManifest decodes a manifest from r. Unversioned manifests are
+// upgraded in memory so that they can be used like current manifests,
+// but their SchemaVersion is left as 0. An error is returned if the
+// manifest was written by a newer, unsupported version of the plugin.
+func DecodeManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	err := json.NewDecoder(r).Decode(&manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest - %s", err.Error())
+	}
+
+	if manifest.SchemaVersion > ManifestSchemaVersion {
+		return nil, fmt.Errorf("manifest schema version %d is not supported (the newest supported version is %d)",
+			manifest.SchemaVersion, ManifestSchemaVersion)
+	}
+
+	if manifest.SchemaVersion == 0 {
+		upgradeUnversionedManifest(&manifest)
+	}
+
+	return &manifest, nil
+}
+
+// upgradeUnversionedManifest fills in the fields that were missing
+// from the original, unversioned manifest format. That format only
+// supported a single JSON template, which was stored at the path
+// specified by 'packer_template_path'.
+func upgradeUnversionedManifest(manifest *Manifest) {
+	if len(manifest.TemplateFiles) == 0 && len(manifest.PackerTemplate) > 0 {
+		manifest.TemplateFiles = []FileMeta{
+			{
+				Name:         manifest.PackerTemplate,
+				StoredAtPath: manifest.PackerTemplate,
+				Source:       LocalStorage,
+			},
+		}
+	}
+
+	if len(manifest.TemplateFormat) == 0 {
+		manifest.TemplateFormat = JsonTemplate
+	}
+}
+
+// Breadcrumbs provides read access to a breadcrumbs directory or
+// an archive of one.
+type Breadcrumbs struct {
+	// Manifest is the decoded breadcrumbs manifest.
+	Manifest *Manifest
+
+	storage breadcrumbsStorage
+}
+
+// breadcrumbsStorage provides access to the files stored in a breadcrumbs
+// directory or archive. File paths are slash separated, and are relative
+// to the directory containing the manifest.
+type breadcrumbsStorage interface {
+	open(filePath string) (io.ReadCloser, error)
+	list() ([]string, error)
+	close() error
+}
+
+// Open opens a breadcrumbs directory, or an archive of a breadcrumbs
+// directory, and decodes its manifest. Archives are identified by their
+// file extension, which must be one of '.tar', '.tar.gz', '.tgz', or
+// '.zip'. The manifest may be stored at the root of the archive, or
+// in a directory inside of it.
+//
+// The caller must call Close when finished.
+func Open(breadcrumbsPath string) (*Breadcrumbs, error) {
+	info, err := os.Stat(breadcrumbsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var storage breadcrumbsStorage
+
+	lower := strings.ToLower(breadcrumbsPath)
+
+	switch {
+	case info.IsDir():
+		storage = &dirStorage{
+			dirPath: breadcrumbsPath,
+		}
+	case strings.HasSuffix(lower, ".zip"):
+		storage, err = newZipStorage(breadcrumbsPath)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		storage, err = newTarStorage(breadcrumbsPath, true)
+	case strings.HasSuffix(lower, ".tar"):
+		storage, err = newTarStorage(breadcrumbsPath, false)
+	default:
+		return nil, fmt.Errorf("'%s' is not a directory or a supported archive", breadcrumbsPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := storage.open(ManifestFileName)
+	if err != nil {
+		storage.close()
+		return nil, err
+	}
+	defer r.Close()
+
+	manifest, err := DecodeManifest(r)
+	if err != nil {
+		storage.close()
+		return nil, err
+	}
+
+	return &Breadcrumbs{
+		Manifest: manifest,
+		storage:  storage,
+	}, nil
+}
+
+// OpenFile opens a stored file described by a FileMeta from the
+// manifest. The returned error satisfies os.IsNotExist if the file
+// is not stored in the breadcrumbs.
+func (o *Breadcrumbs) OpenFile(meta FileMeta) (io.ReadCloser, error) {
+	return o.storage.open(meta.StoredAtPath)
+}
+
+// OpenTemplate opens a stored packer template by its name. If name
+// is empty and the breadcrumbs contain exactly one template, that
+// template is opened.
+func (o *Breadcrumbs) OpenTemplate(name string) (io.ReadCloser, error) {
+	for _, meta := range o.Manifest.TemplateFiles {
+		if meta.Name == name || (len(name) == 0 && len(o.Manifest.TemplateFiles) == 1) {
+			return o.OpenFile(meta)
+		}
+	}
+
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// Packages returns the package list that was captured when the plugin's
+// 'capture_packages' option was enabled. It returns nil if no package
+// list was captured.
+func (o *Breadcrumbs) Packages() (*PackageInventory, error) {
+	if len(o.Manifest.PackagesFile) == 0 {
+		return nil, nil
+	}
+
+	r, err := o.storage.open(o.Manifest.PackagesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var packages PackageInventory
+	err = json.NewDecoder(r).Decode(&packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse package list - %s", err.Error())
+	}
+
+	return &packages, nil
+}
+
+// Files returns the sorted, slash separated paths of every file stored
+// in the breadcrumbs, including the manifest.
+func (o *Breadcrumbs) Files() ([]string, error) {
+	files, err := o.storage.list()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Close releases any resources used by the breadcrumbs.
+func (o *Breadcrumbs) Close() error {
+	return o.storage.close()
+}
+
+// cleanStoredPath cleans a stored file path and returns an error if
+// it refers to a file outside of the breadcrumbs.
+func cleanStoredPath(filePath string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(filePath))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("stored file path '%s' is outside of the breadcrumbs", filePath)
+	}
+
+	return cleaned, nil
+}
+
+type dirStorage struct {
+	dirPath string
+}
+
+func (o *dirStorage) open(filePath string) (io.ReadCloser, error) {
+	cleaned, err := cleanStoredPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(filepath.Join(o.dirPath, filepath.FromSlash(cleaned)))
+}
+
+func (o *dirStorage) list() ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(o.dirPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(o.dirPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (o *dirStorage) close() error {
+	return nil
+}
+
+// archiveRootDir returns the directory containing the manifest in a
+// list of archive entries (or an empty string if the manifest is at
+// the root of the archive).
+func archiveRootDir(archivePath string, names []string) (string, error) {
+	rootDir := ""
+	found := false
+
+	for _, name := range names {
+		cleaned := path.Clean(strings.TrimPrefix(name, "/"))
+		if path.Base(cleaned) != ManifestFileName {
+			continue
+		}
+
+		dir := path.Dir(cleaned)
+		if dir == "." {
+			dir = ""
+		}
+
+		if !found || len(dir) < len(rootDir) {
+			rootDir = dir
+			found = true
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("archive '%s' does not contain a %s file", archivePath, ManifestFileName)
+	}
+
+	return rootDir, nil
+}
+
+// archiveRelPath returns the path of an archive entry relative to the
+// archive's root directory, or false if the entry is not in it.
+func archiveRelPath(rootDir string, name string) (string, bool) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "/"))
+	if len(rootDir) == 0 {
+		return cleaned, true
+	}
+
+	if !strings.HasPrefix(cleaned, rootDir+"/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(cleaned, rootDir+"/"), true
+}
+
+// tarStorage extracts the files in a tar archive to a temporary
+// directory, since tar archives cannot be read randomly. Entries are
+// streamed to disk rather than held in memory, so large archives can
+// be opened.
+type tarStorage struct {
+	tempDirPath string
+	files       map[string]string
+}
+
+func newTarStorage(archivePath string, isGzipped bool) (*tarStorage, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+
+	if isGzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip archive '%s' - %s", archivePath, err.Error())
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	tempDirPath, err := ioutil.TempDir("", "breadcrumbs-tar-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory for tar archive - %s", err.Error())
+	}
+
+	storage := &tarStorage{
+		tempDirPath: tempDirPath,
+		files:       make(map[string]string),
+	}
+
+	err = storage.extract(archivePath, r)
+	if err != nil {
+		storage.close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// extract copies each regular file in the tar archive to the storage's
+// temporary directory. Extracted files are named by their position in
+// the archive, so entry names never determine where they are written.
+func (o *tarStorage) extract(archivePath string, r io.Reader) error {
+	entries := make(map[string]string)
+	var names []string
+
+	tr := tar.NewReader(r)
+
+	for i := 0; ; i++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive '%s' - %s", archivePath, err.Error())
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		extractedPath := filepath.Join(o.tempDirPath, strconv.Itoa(i))
+
+		err = extractTarEntry(tr, extractedPath)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s' from tar archive '%s' - %s",
+				header.Name, archivePath, err.Error())
+		}
+
+		if _, ok := entries[header.Name]; !ok {
+			names = append(names, header.Name)
+		}
+
+		entries[header.Name] = extractedPath
+	}
+
+	rootDir, err := archiveRootDir(archivePath, names)
+	if err != nil {
+		return err
+	}
+
+	for name, extractedPath := range entries {
+		rel, ok := archiveRelPath(rootDir, name)
+		if ok {
+			o.files[rel] = extractedPath
+		}
+	}
+
+	return nil
+}
+
+func extractTarEntry(r io.Reader, destPath string) error {
+	f, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (o *tarStorage) open(filePath string) (io.ReadCloser, error) {
+	cleaned, err := cleanStoredPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	extractedPath, ok := o.files[cleaned]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+
+	return os.Open(extractedPath)
+}
+
+func (o *tarStorage) list() ([]string, error) {
+	files := make([]string, 0, len(o.files))
+	for name := range o.files {
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+func (o *tarStorage) close() error {
+	return os.RemoveAll(o.tempDirPath)
+}
+
+type zipStorage struct {
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+}
+
+func newZipStorage(archivePath string) (*zipStorage, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive '%s' - %s", archivePath, err.Error())
+	}
+
+	var names []string
+	for _, f := range reader.File {
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+		}
+	}
+
+	rootDir, err := archiveRootDir(archivePath, names)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	storage := &zipStorage{
+		reader: reader,
+		files:  make(map[string]*zip.File),
+	}
+
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rel, ok := archiveRelPath(rootDir, f.Name)
+		if ok {
+			storage.files[rel] = f
+		}
+	}
+
+	return storage, nil
+}
+
+func (o *zipStorage) open(filePath string) (io.ReadCloser, error) {
+	cleaned, err := cleanStoredPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := o.files[cleaned]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+
+	return f.Open()
+}
+
+func (o *zipStorage) list() ([]string, error) {
+	files := make([]string, 0, len(o.files))
+	for name := range o.files {
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+func (o *zipStorage) close() error {
+	return o.reader.Close()
+}
@@ -0,0 +1,283 @@
+package breadcrumbs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenDir(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	b, err := Open(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	testOpenedBreadcrumbs(t, b, manifest)
+}
+
+func TestOpenArchives(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	archivePaths := []string{
+		filepath.Join(filepath.Dir(rootDirPath), "breadcrumbs.tar.gz"),
+		filepath.Join(filepath.Dir(rootDirPath), "breadcrumbs.zip"),
+	}
+
+	writeTestTarGz(t, archivePaths[0], rootDirPath)
+	writeTestZip(t, archivePaths[1], rootDirPath)
+
+	for _, archivePath := range archivePaths {
+		b, err := Open(archivePath)
+		if err != nil {
+			t.Fatalf("failed to open '%s' - %s", archivePath, err.Error())
+		}
+
+		testOpenedBreadcrumbs(t, b, manifest)
+
+		result, err := Verify(b)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if !result.Ok() {
+			t.Fatalf("archive '%s' should verify - got %+v", archivePath, result)
+		}
+
+		b.Close()
+	}
+}
+
+func TestOpenTarRemovesExtractedFiles(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	archivePath := filepath.Join(filepath.Dir(rootDirPath), "breadcrumbs.tar.gz")
+
+	writeTestTarGz(t, archivePath, rootDirPath)
+
+	b, err := Open(archivePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	testOpenedBreadcrumbs(t, b, manifest)
+
+	tempDirPath := b.storage.(*tarStorage).tempDirPath
+
+	_, err = os.Stat(tempDirPath)
+	if err != nil {
+		t.Fatalf("archive should be extracted to a temporary directory - %s", err.Error())
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = os.Stat(tempDirPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("temporary directory '%s' should be removed when closed - got %v", tempDirPath, err)
+	}
+}
+
+func TestDecodeManifestUnversioned(t *testing.T) {
+	raw := `{
+    "plugin_version": "0.0.1",
+    "packer_template_path": "abc123",
+    "found_files": [
+        {
+            "name": "ks.cfg",
+            "found_at_path": "/project/ks.cfg",
+            "stored_at_path": "def456",
+            "source": "local_storage"
+        }
+    ]
+}`
+
+	manifest, err := DecodeManifest(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if manifest.SchemaVersion != 0 {
+		t.Fatalf("schema version should be 0 - got %d", manifest.SchemaVersion)
+	}
+
+	if manifest.TemplateFormat != JsonTemplate {
+		t.Fatalf("template format should be '%s' - got '%s'", JsonTemplate, manifest.TemplateFormat)
+	}
+
+	if len(manifest.TemplateFiles) != 1 || manifest.TemplateFiles[0].StoredAtPath != "abc123" {
+		t.Fatalf("expected the template to be stored at 'abc123' - got %+v", manifest.TemplateFiles)
+	}
+
+	if len(manifest.FoundFiles) != 1 || manifest.FoundFiles[0].StoredAtPath != "def456" {
+		t.Fatalf("unexpected found files - got %+v", manifest.FoundFiles)
+	}
+}
+
+func TestDecodeManifestUnsupportedVersion(t *testing.T) {
+	_, err := DecodeManifest(strings.NewReader(`{"schema_version": 9999}`))
+	if err == nil {
+		t.Fatal("expected an error for an unsupported schema version")
+	}
+}
+
+func testOpenedBreadcrumbs(t *testing.T, b *Breadcrumbs, manifest *Manifest) {
+	if b.Manifest.SchemaVersion != ManifestSchemaVersion {
+		t.Fatalf("schema version should be %d - got %d", ManifestSchemaVersion, b.Manifest.SchemaVersion)
+	}
+
+	if len(b.Manifest.FoundFiles) != len(manifest.FoundFiles) {
+		t.Fatalf("expected %d found files - got %d", len(manifest.FoundFiles), len(b.Manifest.FoundFiles))
+	}
+
+	r, err := b.OpenFile(b.Manifest.FoundFiles[0])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	raw, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if string(raw) != "echo a.sh\n" {
+		t.Fatalf("unexpected stored file contents - got '%s'", raw)
+	}
+
+	r, err = b.OpenTemplate("")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	raw, err = ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if string(raw) != string(positiveTestFileContents) {
+		t.Fatal("stored template does not match the original template")
+	}
+
+	_, err = b.OpenFile(FileMeta{StoredAtPath: "nope"})
+	if !os.IsNotExist(err) {
+		t.Fatalf("opening a missing file should fail with a not exist error - got %v", err)
+	}
+
+	_, err = b.OpenFile(FileMeta{StoredAtPath: "../" + ManifestFileName})
+	if err == nil {
+		t.Fatal("opening a file outside of the breadcrumbs should fail")
+	}
+}
+
+// writeTestTarGz archives the files in dirPath into a directory
+// named 'breadcrumbs' inside of a tar.gz archive.
+func writeTestTarGz(t *testing.T, archivePath string, dirPath string) {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	walkTestDir(t, dirPath, func(name string, raw []byte) {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     "breadcrumbs/" + name,
+			Mode:     0600,
+			Size:     int64(len(raw)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, err = tw.Write(raw)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// writeTestZip archives the files in dirPath into the root of a
+// zip archive.
+func writeTestZip(t *testing.T, archivePath string, dirPath string) {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	walkTestDir(t, dirPath, func(name string, raw []byte) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, err = io.WriteString(w, string(raw))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	})
+
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func walkTestDir(t *testing.T, dirPath string, fn func(string, []byte)) {
+	err := filepath.Walk(dirPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		raw, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		fn(filepath.ToSlash(rel), raw)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,293 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// RedactionMarker replaces sensitive values when 'redaction_mode'
+	// is 'marker'.
+	RedactionMarker = "<redacted>"
+
+	// MarkerRedaction replaces sensitive values with RedactionMarker.
+	MarkerRedaction = "marker"
+
+	// HashRedaction replaces sensitive values with a salted hash
+	// (HMAC-SHA256) of the value. This allows a changed value to
+	// be detected without revealing it.
+	HashRedaction = "hash"
+
+	hashRedactionPrefix = "hmac-sha256:"
+)
+
+// secretValuePatterns match values that look like well known types of
+// secrets. They are used when 'redact_secret_values' is enabled.
+var secretValuePatterns = []*regexp.Regexp{
+	// AWS access key IDs.
+	regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`),
+	// GitHub tokens.
+	regexp.MustCompile(`^(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})$`),
+	// GitLab personal access tokens.
+	regexp.MustCompile(`^glpat-[A-Za-z0-9_-]{20,}$`),
+	// Slack tokens.
+	regexp.MustCompile(`^xox[abposr]-[A-Za-z0-9-]{10,}$`),
+	// JSON web tokens.
+	regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`),
+	// PEM encoded private keys.
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	// URLs containing a password.
+	regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`),
+}
+
+// redactor replaces the values of sensitive packer user variables.
+type redactor struct {
+	namePatterns  []string
+	valuePatterns []*regexp.Regexp
+	mode          string
+	salt          []byte
+}
+
+func newRedactor(config *PluginConfig) (*redactor, error) {
+	r := &redactor{
+		mode: config.RedactionMode,
+		salt: []byte(config.RedactionSalt),
+	}
+
+	switch r.mode {
+	case "":
+		r.mode = MarkerRedaction
+	case MarkerRedaction, HashRedaction:
+	default:
+		return nil, fmt.Errorf("redaction mode '%s' is not supported - must be '%s' or '%s'",
+			r.mode, MarkerRedaction, HashRedaction)
+	}
+
+	for _, pattern := range config.RedactVariablePatterns {
+		pattern = strings.ToLower(pattern)
+
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redaction variable pattern '%s' - %s", pattern, err.Error())
+		}
+
+		r.namePatterns = append(r.namePatterns, pattern)
+	}
+
+	for _, pattern := range config.RedactValuePatterns {
+		exp, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redaction value pattern '%s' - %s", pattern, err.Error())
+		}
+
+		r.valuePatterns = append(r.valuePatterns, exp)
+	}
+
+	if config.RedactSecretValues {
+		r.valuePatterns = append(r.valuePatterns, secretValuePatterns...)
+	}
+
+	// A random salt prevents the hashes from being compared to
+	// the hashes of guessed values, but it also prevents them
+	// from being compared across builds. The salt is needed in
+	// both modes, since it is also used by storedPath.
+	if len(r.salt) == 0 {
+		r.salt = make([]byte, 32)
+
+		_, err := rand.Read(r.salt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate redaction salt - %s", err.Error())
+		}
+	}
+
+	return r, nil
+}
+
+func (o *redactor) isSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, pattern := range o.namePatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *redactor) isSensitiveValue(value string) bool {
+	for _, exp := range o.valuePatterns {
+		if exp.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *redactor) replacement(value string) string {
+	if o.mode == HashRedaction {
+		mac := hmac.New(sha256.New, o.salt)
+		mac.Write([]byte(value))
+
+		return hashRedactionPrefix + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return RedactionMarker
+}
+
+// storedPath returns the name used to store a found file whose path
+// was redacted. Unlike the unsalted hash that is normally used, it
+// cannot be compared to the hashes of guessed paths.
+func (o *redactor) storedPath(filePath string) string {
+	mac := hmac.New(sha256.New, o.salt)
+	mac.Write([]byte(filePath))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactVariables returns a copy of vars in which sensitive values are
+// replaced. A variable is sensitive if its name is in sensitiveNames or
+// matches a name pattern, or if its value is in sensitiveValues or
+// matches a value pattern. The template's default values are checked
+// as well, since they are stored in the template copy.
+//
+// The sorted names of the redacted variables are returned, along with
+// a map of each redacted value to its replacement.
+func (o *redactor) redactVariables(vars map[string]string, defaults map[string]string,
+	sensitiveNames []string, sensitiveValues []string) (map[string]string, []string, map[string]string) {
+	isSensitiveName := make(map[string]bool)
+	for _, name := range sensitiveNames {
+		isSensitiveName[name] = true
+	}
+
+	isSensitiveValue := make(map[string]bool)
+	for _, value := range sensitiveValues {
+		if len(value) > 0 {
+			isSensitiveValue[value] = true
+		}
+	}
+
+	isSensitive := func(name string, value string) bool {
+		if len(value) == 0 {
+			return false
+		}
+
+		return isSensitiveName[name] || o.isSensitiveName(name) ||
+			isSensitiveValue[value] || o.isSensitiveValue(value)
+	}
+
+	secrets := make(map[string]string)
+
+	for name, value := range defaults {
+		if isSensitive(name, value) {
+			secrets[value] = o.replacement(value)
+		}
+	}
+
+	var redacted map[string]string
+	if vars != nil {
+		redacted = make(map[string]string, len(vars))
+	}
+
+	var names []string
+
+	for name, value := range vars {
+		if !isSensitive(name, value) {
+			redacted[name] = value
+			continue
+		}
+
+		replacement := o.replacement(value)
+		redacted[name] = replacement
+		secrets[value] = replacement
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return redacted, names, secrets
+}
+
+// redactTemplate replaces the quoted string literals in a template
+// that contain a secret. Only whole literals are replaced so that
+// short secrets do not corrupt unrelated parts of the template.
+func redactTemplate(raw []byte, secrets map[string]string) []byte {
+	for _, value := range sortedSecretValues(secrets) {
+		replacement := []byte(`"` + secrets[value] + `"`)
+
+		raw = bytes.Replace(raw, []byte(`"`+value+`"`), replacement, -1)
+
+		quoted := jsonQuote(value)
+		if quoted != `"`+value+`"` {
+			raw = bytes.Replace(raw, []byte(quoted), replacement, -1)
+		}
+	}
+
+	return raw
+}
+
+// urlUserInfoRegexp matches the scheme and user info of URLs
+// that contain credentials.
+var urlUserInfoRegexp = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#\s@]+@`)
+
+// redactText removes the credentials from the URLs in s, and replaces
+// the secrets (including URL escaped secrets) that it contains. Unlike
+// redactTemplate, secrets are replaced anywhere in s. It is used for
+// values that are not stored in the template, such as the paths of
+// found files that were created by resolving user variables.
+func redactText(s string, secrets map[string]string) string {
+	s = urlUserInfoRegexp.ReplaceAllString(s, "$1")
+
+	for _, value := range sortedSecretValues(secrets) {
+		replacement := secrets[value]
+
+		s = strings.Replace(s, value, replacement, -1)
+
+		for _, escaped := range []string{url.QueryEscape(value), url.PathEscape(value)} {
+			if escaped != value {
+				s = strings.Replace(s, escaped, replacement, -1)
+			}
+		}
+	}
+
+	return s
+}
+
+// sortedSecretValues returns the secret values from longest to
+// shortest. Longer secrets are replaced first in case one secret
+// contains another.
+func sortedSecretValues(secrets map[string]string) []string {
+	values := make([]string, 0, len(secrets))
+	for value := range secrets {
+		values = append(values, value)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	return values
+}
+
+// jsonQuote returns a string as a JSON string literal without
+// escaping HTML characters.
+func jsonQuote(s string) string {
+	buffer := bytes.NewBuffer(nil)
+
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
@@ -0,0 +1,486 @@
+package breadcrumbs
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CycloneDxSbomFormat is the 'sbom_formats' value for
+	// CycloneDX JSON documents.
+	CycloneDxSbomFormat = "cyclonedx"
+
+	// SpdxSbomFormat is the 'sbom_formats' value for SPDX
+	// JSON documents.
+	SpdxSbomFormat = "spdx"
+
+	// CycloneDxSbomFileName is the name of the CycloneDX document
+	// stored in the breadcrumbs directory.
+	CycloneDxSbomFileName = "sbom.cdx.json"
+
+	// SpdxSbomFileName is the name of the SPDX document stored
+	// in the breadcrumbs directory.
+	SpdxSbomFileName = "sbom.spdx.json"
+
+	sbomToolName = "packer-provisioner-breadcrumbs"
+)
+
+// sbomFileName returns the name of the document for an SBOM format,
+// or an empty string if the format is not supported.
+func sbomFileName(format string) string {
+	switch format {
+	case CycloneDxSbomFormat:
+		return CycloneDxSbomFileName
+	case SpdxSbomFormat:
+		return SpdxSbomFileName
+	}
+
+	return ""
+}
+
+// newSbom creates an SBOM document describing the image. The image is
+// the root component, and the operating system, installed packages, and
+// found files are its components.
+func newSbom(format string, manifest *Manifest, now time.Time) ([]byte, error) {
+	var document interface{}
+	var err error
+
+	switch format {
+	case CycloneDxSbomFormat:
+		document, err = newCycloneDxSbom(manifest, now)
+	case SpdxSbomFormat:
+		document, err = newSpdxSbom(manifest, now)
+	default:
+		return nil, fmt.Errorf("unsupported sbom format '%s'", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.MarshalIndent(document, jsonPrefix, jsonIndent)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(raw, '\n'), nil
+}
+
+// sbomImageName returns the name of the image, which is the packer build
+// name if one was provided.
+func sbomImageName(manifest *Manifest) string {
+	if len(manifest.PackerBuildName) > 0 {
+		return manifest.PackerBuildName
+	}
+
+	return "image"
+}
+
+// packageUrl returns the package URL (purl) of a package, or an empty
+// string if the package manager does not have a purl type.
+func packageUrl(manager string, osName string, p Package) string {
+	var purlType string
+	namespace := osName
+
+	switch manager {
+	case rpmPackageManager:
+		purlType = "rpm"
+	case dpkgPackageManager:
+		purlType = "deb"
+	case apkPackageManager:
+		purlType = "apk"
+	case pacmanPackageManager:
+		purlType = "alpm"
+	default:
+		return ""
+	}
+
+	purl := "pkg:" + purlType + "/"
+	if len(namespace) > 0 {
+		purl = purl + url.PathEscape(namespace) + "/"
+	}
+
+	purl = purl + url.PathEscape(p.Name)
+	if len(p.Version) > 0 {
+		purl = purl + "@" + url.PathEscape(p.Version)
+	}
+
+	if len(p.Arch) > 0 {
+		purl = purl + "?arch=" + url.QueryEscape(p.Arch)
+	}
+
+	return purl
+}
+
+func newUuid() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate uuid - %s", err.Error())
+	}
+
+	// Set the version (4) and variant (RFC 4122) bits.
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+type cycloneDxBom struct {
+	BomFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	SerialNumber string                `json:"serialNumber"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDxMetadata     `json:"metadata"`
+	Components   []cycloneDxComponent  `json:"components"`
+	Dependencies []cycloneDxDependency `json:"dependencies"`
+}
+
+type cycloneDxMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDxTools     `json:"tools"`
+	Component cycloneDxComponent `json:"component"`
+}
+
+type cycloneDxTools struct {
+	Components []cycloneDxComponent `json:"components"`
+}
+
+type cycloneDxComponent struct {
+	Type               string                 `json:"type"`
+	BomRef             string                 `json:"bom-ref,omitempty"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	Publisher          string                 `json:"publisher,omitempty"`
+	Purl               string                 `json:"purl,omitempty"`
+	Hashes             []cycloneDxHash        `json:"hashes,omitempty"`
+	ExternalReferences []cycloneDxExternalRef `json:"externalReferences,omitempty"`
+	Properties         []cycloneDxProperty    `json:"properties,omitempty"`
+}
+
+type cycloneDxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDxExternalRef struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+type cycloneDxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+func newCycloneDxSbom(manifest *Manifest, now time.Time) (*cycloneDxBom, error) {
+	serialUuid, err := newUuid()
+	if err != nil {
+		return nil, err
+	}
+
+	image := cycloneDxComponent{
+		Type:    "platform",
+		BomRef:  "image",
+		Name:    sbomImageName(manifest),
+		Version: manifest.GitRevision,
+	}
+
+	addProperty := func(c *cycloneDxComponent, name string, value string) {
+		if len(value) > 0 {
+			c.Properties = append(c.Properties, cycloneDxProperty{
+				Name:  "breadcrumbs:" + name,
+				Value: value,
+			})
+		}
+	}
+
+	addProperty(&image, "packer_build_name", manifest.PackerBuildName)
+	addProperty(&image, "packer_build_type", manifest.PackerBuildType)
+	addProperty(&image, "git_revision", manifest.GitRevision)
+	addProperty(&image, "architecture", manifest.Architecture)
+
+	var components []cycloneDxComponent
+
+	if len(manifest.OSName) > 0 {
+		osComponent := cycloneDxComponent{
+			Type:    "operating-system",
+			BomRef:  "os",
+			Name:    manifest.OSName,
+			Version: manifest.OSVersion,
+		}
+		addProperty(&osComponent, "kernel_release", manifest.KernelRelease)
+
+		components = append(components, osComponent)
+	}
+
+	if manifest.packages != nil {
+		for i, p := range manifest.packages.Packages {
+			c := cycloneDxComponent{
+				Type:    "library",
+				BomRef:  "package-" + strconv.Itoa(i),
+				Name:    p.Name,
+				Version: p.Version,
+				Purl:    packageUrl(manifest.packages.PackageManager, manifest.OSName, p),
+			}
+
+			if manifest.packages.PackageManager == windowsPackageManager {
+				c.Type = "application"
+				c.Publisher = p.SourceRepo
+			} else {
+				addProperty(&c, "source_repo", p.SourceRepo)
+			}
+			addProperty(&c, "arch", p.Arch)
+
+			components = append(components, c)
+		}
+	}
+
+	for i, f := range manifest.FoundFiles {
+		c := cycloneDxComponent{
+			Type:   "file",
+			BomRef: "file-" + strconv.Itoa(i),
+			Name:   f.Name,
+		}
+
+		if len(f.Sha256) > 0 {
+			c.Hashes = append(c.Hashes, cycloneDxHash{Alg: "SHA-256", Content: f.Sha256})
+		}
+
+		if len(f.Sha512) > 0 {
+			c.Hashes = append(c.Hashes, cycloneDxHash{Alg: "SHA-512", Content: f.Sha512})
+		}
+
+		if f.Source == HttpHost || f.Source == HttpsHost {
+			c.ExternalReferences = append(c.ExternalReferences, cycloneDxExternalRef{
+				Type: "distribution",
+				Url:  f.FoundAtPath,
+			})
+		}
+
+		addProperty(&c, "found_at_path", f.FoundAtPath)
+		addProperty(&c, "resolved_path", f.ResolvedPath)
+		addProperty(&c, "stored_at_path", f.StoredAtPath)
+
+		components = append(components, c)
+	}
+
+	imageDependency := cycloneDxDependency{
+		Ref: image.BomRef,
+	}
+
+	for _, c := range components {
+		imageDependency.DependsOn = append(imageDependency.DependsOn, c.BomRef)
+	}
+
+	if components == nil {
+		components = []cycloneDxComponent{}
+	}
+
+	return &cycloneDxBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + serialUuid,
+		Version:      1,
+		Metadata: cycloneDxMetadata{
+			Timestamp: now.UTC().Format(time.RFC3339),
+			Tools: cycloneDxTools{
+				Components: []cycloneDxComponent{
+					{
+						Type:    "application",
+						Name:    sbomToolName,
+						Version: manifest.PluginVersion,
+					},
+				},
+			},
+			Component: image,
+		},
+		Components:   components,
+		Dependencies: []cycloneDxDependency{imageDependency},
+	}, nil
+}
+
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SpdxId            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SpdxId                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	Supplier              string            `json:"supplier,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxFile struct {
+	SpdxId    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+	Comment   string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+func newSpdxSbom(manifest *Manifest, now time.Time) (*spdxDocument, error) {
+	const noAssertion = "NOASSERTION"
+
+	namespaceUuid, err := newUuid()
+	if err != nil {
+		return nil, err
+	}
+
+	imageName := sbomImageName(manifest)
+
+	document := &spdxDocument{
+		SpdxVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SpdxId:            "SPDXRef-DOCUMENT",
+		Name:              imageName,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + url.PathEscape(imageName) + "-" + namespaceUuid,
+		CreationInfo: spdxCreationInfo{
+			Created:  now.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + strings.TrimSuffix(sbomToolName+"-"+manifest.PluginVersion, "-")},
+		},
+	}
+
+	image := spdxPackage{
+		SpdxId:           "SPDXRef-Image",
+		Name:             imageName,
+		VersionInfo:      manifest.GitRevision,
+		DownloadLocation: noAssertion,
+		Comment:          fmt.Sprintf("packer build type: %s", manifest.PackerBuildType),
+	}
+
+	document.Packages = append(document.Packages, image)
+	document.Relationships = append(document.Relationships, spdxRelationship{
+		SpdxElementId:      document.SpdxId,
+		RelationshipType:   "DESCRIBES",
+		RelatedSpdxElement: image.SpdxId,
+	})
+
+	contains := func(spdxId string) {
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SpdxElementId:      image.SpdxId,
+			RelationshipType:   "CONTAINS",
+			RelatedSpdxElement: spdxId,
+		})
+	}
+
+	if len(manifest.OSName) > 0 {
+		osPackage := spdxPackage{
+			SpdxId:                "SPDXRef-OperatingSystem",
+			Name:                  manifest.OSName,
+			VersionInfo:           manifest.OSVersion,
+			DownloadLocation:      noAssertion,
+			PrimaryPackagePurpose: "OPERATING-SYSTEM",
+		}
+
+		document.Packages = append(document.Packages, osPackage)
+		contains(osPackage.SpdxId)
+	}
+
+	if manifest.packages != nil {
+		for i, p := range manifest.packages.Packages {
+			spdxPkg := spdxPackage{
+				SpdxId:           "SPDXRef-Package-" + strconv.Itoa(i),
+				Name:             p.Name,
+				VersionInfo:      p.Version,
+				DownloadLocation: noAssertion,
+			}
+
+			if manifest.packages.PackageManager == windowsPackageManager {
+				spdxPkg.PrimaryPackagePurpose = "APPLICATION"
+				if len(p.SourceRepo) > 0 {
+					spdxPkg.Supplier = "Organization: " + p.SourceRepo
+				}
+			} else {
+				spdxPkg.PrimaryPackagePurpose = "LIBRARY"
+				if len(p.SourceRepo) > 0 {
+					spdxPkg.Comment = "source repo: " + p.SourceRepo
+				}
+			}
+
+			purl := packageUrl(manifest.packages.PackageManager, manifest.OSName, p)
+			if len(purl) > 0 {
+				spdxPkg.ExternalRefs = append(spdxPkg.ExternalRefs, spdxExternalRef{
+					ReferenceCategory: "PACKAGE-MANAGER",
+					ReferenceType:     "purl",
+					ReferenceLocator:  purl,
+				})
+			}
+
+			document.Packages = append(document.Packages, spdxPkg)
+			contains(spdxPkg.SpdxId)
+		}
+	}
+
+	for i, f := range manifest.FoundFiles {
+		file := spdxFile{
+			SpdxId:   "SPDXRef-File-" + strconv.Itoa(i),
+			FileName: "./" + f.StoredAtPath,
+			Comment:  fmt.Sprintf("%s found at: %s", f.Name, f.FoundAtPath),
+		}
+
+		if len(f.Sha1) > 0 {
+			file.Checksums = append(file.Checksums, spdxChecksum{Algorithm: "SHA1", ChecksumValue: f.Sha1})
+		}
+
+		if len(f.Sha256) > 0 {
+			file.Checksums = append(file.Checksums, spdxChecksum{Algorithm: "SHA256", ChecksumValue: f.Sha256})
+		}
+
+		if len(f.Sha512) > 0 {
+			file.Checksums = append(file.Checksums, spdxChecksum{Algorithm: "SHA512", ChecksumValue: f.Sha512})
+		}
+
+		if file.Checksums == nil {
+			file.Checksums = []spdxChecksum{}
+		}
+
+		document.Files = append(document.Files, file)
+		contains(file.SpdxId)
+	}
+
+	return document, nil
+}
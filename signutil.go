@@ -0,0 +1,148 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// SignatureFileName is the name of the manifest's detached
+	// signature file, which is stored in the breadcrumbs directory
+	// when 'signing_key_path' is set. The file contains the base64
+	// encoded ed25519 signature of the manifest file.
+	SignatureFileName = ManifestFileName + ".sig"
+)
+
+// SignatureStatus is the result of verifying the manifest's signature.
+type SignatureStatus string
+
+const (
+	ValidSignature   SignatureStatus = "valid"
+	InvalidSignature SignatureStatus = "invalid"
+	MissingSignature SignatureStatus = "missing"
+)
+
+// loadSigningKey loads an ed25519 private key from a PEM encoded PKCS #8
+// file (as created by 'openssl genpkey -algorithm ed25519'), or from an
+// unencrypted OpenSSH private key file (as created by
+// 'ssh-keygen -t ed25519').
+func loadSigningKey(filePath string) (ed25519.PrivateKey, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("signing key '%s' is not pem encoded", filePath)
+	}
+
+	var key interface{}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "OPENSSH PRIVATE KEY":
+		key, err = ssh.ParseRawPrivateKey(raw)
+	default:
+		return nil, fmt.Errorf("signing key '%s' has unsupported pem type '%s'", filePath, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key '%s' - %s", filePath, err.Error())
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ed25519.PrivateKey:
+		return *k, nil
+	}
+
+	return nil, fmt.Errorf("signing key '%s' is a %T, not an ed25519 key", filePath, key)
+}
+
+// LoadPublicKey loads an ed25519 public key from a PEM encoded PKIX file
+// (as created by 'openssl pkey -pubout'), or from an OpenSSH public key
+// file (as created by 'ssh-keygen -t ed25519').
+func LoadPublicKey(filePath string) (ed25519.PublicKey, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+
+	block, _ := pem.Decode(raw)
+	if block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("public key '%s' has unsupported pem type '%s'", filePath, block.Type)
+		}
+
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key '%s' - %s", filePath, err.Error())
+		}
+	} else {
+		sshKey, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key '%s' - %s", filePath, err.Error())
+		}
+
+		cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key '%s' has unsupported type '%s'", filePath, sshKey.Type())
+		}
+
+		key = cryptoKey.CryptoPublicKey()
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key '%s' is a %T, not an ed25519 key", filePath, key)
+	}
+
+	return publicKey, nil
+}
+
+// signManifest returns the contents of the manifest's signature file.
+func signManifest(key ed25519.PrivateKey, manifestJson []byte) []byte {
+	signature := ed25519.Sign(key, manifestJson)
+
+	return []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+}
+
+// VerifySignature verifies the manifest's detached signature using
+// an ed25519 public key.
+func VerifySignature(b *Breadcrumbs, publicKey ed25519.PublicKey) (SignatureStatus, error) {
+	rawSignature, err := readStoredFile(b, FileMeta{StoredAtPath: SignatureFileName})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return MissingSignature, nil
+		}
+		return "", err
+	}
+
+	manifestJson, err := readStoredFile(b, FileMeta{StoredAtPath: ManifestFileName})
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(rawSignature)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return InvalidSignature, nil
+	}
+
+	if !ed25519.Verify(publicKey, manifestJson, signature) {
+		return InvalidSignature, nil
+	}
+
+	return ValidSignature, nil
+}
@@ -0,0 +1,339 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fileTokenDelims are the characters (outside of quotes and packer
+	// variables) that separate one possible file reference from another.
+	fileTokenDelims = " \t\r\n|;&<>()"
+	fileTokenQuotes = "'\"`"
+)
+
+type TemplateFormat string
+
+const (
+	JsonTemplate TemplateFormat = "json"
+	Hcl2Template TemplateFormat = "hcl2"
+)
+
+type templateFile struct {
+	path    string
+	raw     []byte
+	modTime time.Time
+}
+
+// templateString is a string value found in a packer template, along with
+// the JSON pointer (RFC 6901) to where it was found.
+type templateString struct {
+	file    string
+	pointer string
+	value   string
+}
+
+// loadPackerTemplate reads the packer template file(s) found at
+// templatePath. The path can be a JSON template, a single HCL2 template,
+// or a directory containing HCL2 templates.
+func loadPackerTemplate(templatePath string, maxSizeBytes int64) (TemplateFormat, []templateFile, error) {
+	info, err := os.Stat(templatePath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	format := JsonTemplate
+	var filePaths []string
+
+	if info.IsDir() {
+		format = Hcl2Template
+
+		infos, err := ioutil.ReadDir(templatePath)
+		if err != nil {
+			return "", nil, err
+		}
+
+		for i := range infos {
+			if !infos[i].IsDir() && isHcl2TemplateFile(infos[i].Name()) {
+				filePaths = append(filePaths, filepath.Join(templatePath, infos[i].Name()))
+			}
+		}
+
+		if len(filePaths) == 0 {
+			return "", nil, fmt.Errorf("no hcl2 packer templates were found in '%s'", templatePath)
+		}
+	} else {
+		if isHcl2TemplateFile(templatePath) {
+			format = Hcl2Template
+		}
+
+		filePaths = append(filePaths, templatePath)
+	}
+
+	var files []templateFile
+
+	for _, filePath := range filePaths {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if info.Size() > maxSizeBytes {
+			return "", nil, fmt.Errorf("packer template file '%s' size exceedes maximum size of %d byte(s)",
+				filePath, maxSizeBytes)
+		}
+
+		raw, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return "", nil, err
+		}
+
+		files = append(files, templateFile{
+			path:    filePath,
+			raw:     raw,
+			modTime: info.ModTime(),
+		})
+	}
+
+	return format, files, nil
+}
+
+func templateFileName(filePath string) string {
+	return filepath.Base(filePath)
+}
+
+// jsonTemplateStrings decodes a JSON packer template and returns every
+// string value in the order in which it appears in the template.
+func jsonTemplateStrings(raw []byte) ([]templateString, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var results []templateString
+
+	err := walkJsonValue(decoder, "", &results)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse packer template as json - %s", err.Error())
+	}
+
+	return results, nil
+}
+
+// jsonTemplateVariables returns the string default values of the
+// variables declared in a JSON packer template, and the names of the
+// variables listed in 'sensitive-variables'.
+func jsonTemplateVariables(raw []byte) (map[string]string, []string, error) {
+	var template struct {
+		Variables          map[string]interface{} `json:"variables"`
+		SensitiveVariables []string               `json:"sensitive-variables"`
+	}
+
+	err := json.Unmarshal(raw, &template)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse template variables - %s", err.Error())
+	}
+
+	defaults := make(map[string]string)
+
+	for name, value := range template.Variables {
+		if str, ok := value.(string); ok {
+			defaults[name] = str
+		}
+	}
+
+	return defaults, template.SensitiveVariables, nil
+}
+
+func walkJsonValue(decoder *json.Decoder, pointer string, results *[]templateString) error {
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("unexpected end of json")
+		}
+		return err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+
+				key, ok := keyToken.(string)
+				if !ok {
+					return fmt.Errorf("expected object key at '%s'", pointer)
+				}
+
+				err = walkJsonValue(decoder, pointer+"/"+escapeJsonPointer(key), results)
+				if err != nil {
+					return err
+				}
+			}
+		case '[':
+			for i := 0; decoder.More(); i++ {
+				err := walkJsonValue(decoder, pointer+"/"+strconv.Itoa(i), results)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected json delimiter '%s' at '%s'", t, pointer)
+		}
+
+		// Consume the closing delimiter.
+		_, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+	case string:
+		*results = append(*results, templateString{
+			pointer: pointer,
+			value:   t,
+		})
+	}
+
+	return nil
+}
+
+func escapeJsonPointer(key string) string {
+	key = strings.Replace(key, "~", "~0", -1)
+	return strings.Replace(key, "/", "~1", -1)
+}
+
+// filesWithSuffix returns a FileMeta for every token ending in suffix found
+// in the provided template strings. References containing packer variables
+// are marked as unresolved.
+func filesWithSuffix(suffix string, strs []templateString) []FileMeta {
+	var metas []FileMeta
+
+	for _, str := range strs {
+		for _, token := range fileReferenceTokens(str.value) {
+			if len(token) <= len(suffix) || !strings.HasSuffix(token, suffix) {
+				continue
+			}
+
+			var meta FileMeta
+			if strings.Contains(token, startPackerVariable) || strings.Contains(token, hcl2VariableStart) {
+				meta = newUnresolvedFileMeta(token)
+			} else {
+				meta = newFileMeta(token)
+			}
+
+			meta.FoundInTemplate = str.file
+			meta.JsonPointer = str.pointer
+
+			metas = append(metas, meta)
+		}
+	}
+
+	return metas
+}
+
+// fileReferenceTokens splits a string into tokens that might be file
+// references. It understands shell delimiters (e.g., pipes), quotes, and
+// packer variables (which may contain spaces). Assignments such as
+// 'ks=http://x/y.ks' are reduced to their value.
+func fileReferenceTokens(s string) []string {
+	var tokens []string
+	current := bytes.NewBuffer(nil)
+	quoted := bytes.NewBuffer(nil)
+	var quote byte
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, trimAssignment(current.String()))
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if quote == 0 {
+			endIndex := variableEndIndex(s[i:])
+			if endIndex > 0 {
+				current.WriteString(s[i : i+endIndex])
+				i = i + endIndex - 1
+				continue
+			}
+		}
+
+		c := s[i]
+
+		if quote != 0 {
+			if c != quote {
+				quoted.WriteByte(c)
+				continue
+			}
+
+			quote = 0
+
+			// Quotes nested inside of quotes usually mean a
+			// command is being passed to a shell - tokenize it
+			// rather than treating it as a single value.
+			if strings.ContainsAny(quoted.String(), fileTokenQuotes) {
+				flush()
+				tokens = append(tokens, fileReferenceTokens(quoted.String())...)
+			} else {
+				current.Write(quoted.Bytes())
+			}
+
+			quoted.Reset()
+			continue
+		}
+
+		switch {
+		case strings.IndexByte(fileTokenQuotes, c) >= 0:
+			quote = c
+		case strings.IndexByte(fileTokenDelims, c) >= 0:
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	current.Write(quoted.Bytes())
+	flush()
+
+	return tokens
+}
+
+// variableEndIndex returns the index immediately after the packer or HCL2
+// variable that s starts with, or -1 if s does not start with a variable.
+func variableEndIndex(s string) int {
+	var end string
+
+	switch {
+	case strings.HasPrefix(s, startPackerVariable):
+		end = endPackerVariable
+	case strings.HasPrefix(s, hcl2VariableStart):
+		end = hcl2VariableEnd
+	default:
+		return -1
+	}
+
+	endIndex := strings.Index(s, end)
+	if endIndex < 0 {
+		return -1
+	}
+
+	return endIndex + len(end)
+}
+
+func trimAssignment(token string) string {
+	i := strings.IndexByte(token, '=')
+	if i <= 0 || strings.ContainsAny(token[:i], "/:"+packerVariableDelims) {
+		return token
+	}
+
+	return token[i+1:]
+}
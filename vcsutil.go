@@ -0,0 +1,409 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	GitVcs       = "git"
+	MercurialVcs = "hg"
+	NoVcs        = "none"
+)
+
+// defaultTreeHashExcludePatterns match packer's cache and the output
+// directories created by builders, which contain large files that
+// differ between builds.
+var defaultTreeHashExcludePatterns = []string{
+	"packer_cache",
+	"output-*",
+}
+
+// vcsDetector records the state of a version control system's
+// repository in a manifest.
+type vcsDetector interface {
+	// name returns the name recorded in the manifest's 'vcs' field.
+	name() string
+
+	// detect returns true if dirPath is in one of the version
+	// control system's repositories.
+	detect(dirPath string) bool
+
+	// record adds the state of the repository containing
+	// dirPath to the manifest.
+	record(dirPath string, manifest *Manifest, config *PluginConfig) error
+}
+
+// vcsDetectors are the supported version control systems in the order
+// in which they are detected.
+var vcsDetectors = []vcsDetector{
+	&gitDetector{},
+	&mercurialDetector{},
+}
+
+// recordVcs adds the state of the project directory's version control
+// system to the manifest. If the directory is not in a repository, the
+// directory's tree hash is recorded instead if 'allow_no_vcs' is enabled.
+func recordVcs(manifest *Manifest, config *PluginConfig) error {
+	for _, detector := range vcsDetectors {
+		if detector.detect(config.ProjectDirPath) {
+			manifest.VCS = detector.name()
+
+			return detector.record(config.ProjectDirPath, manifest, config)
+		}
+	}
+
+	if !config.AllowNoVcs {
+		return fmt.Errorf("'%s' is not in a git or mercurial repository; set 'allow_no_vcs' to 'true' to record a hash of the directory instead",
+			config.ProjectDirPath)
+	}
+
+	excludePatterns := append(append([]string{}, defaultTreeHashExcludePatterns...), config.TreeHashExcludePatterns...)
+
+	treeHash, err := hashTree(config.ProjectDirPath, config.ArtifactsDirPath, excludePatterns)
+	if err != nil {
+		return fmt.Errorf("failed to hash project directory - %s", err.Error())
+	}
+
+	manifest.VCS = NoVcs
+	manifest.TreeHash = treeHash
+
+	return nil
+}
+
+// hashTree returns a deterministic hash of the regular files and
+// symbolic links in a directory. The hash is the SHA256 of a sorted
+// list of each file's SHA256, mode, and path (relative to dirPath,
+// using forward slashes). A symbolic link's hash is the hash of its
+// target. excludeDirPath is skipped if it is in dirPath (so that
+// artifacts from previous builds do not change the hash). Files and
+// directories matching any of excludePatterns are skipped as well
+// (see matchesTreePattern).
+func hashTree(dirPath string, excludeDirPath string, excludePatterns []string) (string, error) {
+	var excludeAbs string
+	if len(excludeDirPath) > 0 {
+		var err error
+		excludeAbs, err = filepath.Abs(excludeDirPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var lines []string
+
+	err := filepath.Walk(dirPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && matchesTreePattern(filepath.ToSlash(rel), excludePatterns) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if len(excludeAbs) > 0 {
+				abs, err := filepath.Abs(filePath)
+				if err != nil {
+					return err
+				}
+
+				if abs == excludeAbs {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		var fileHash string
+		mode := "file"
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+
+			mode = "symlink"
+			fileHash = hashBytes([]byte(filepath.ToSlash(target)))
+		case info.Mode().IsRegular():
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+
+			hash := sha256.New()
+			_, err = io.Copy(hash, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+			fileHash = fmt.Sprintf("%x", hash.Sum(nil))
+
+			if info.Mode()&0111 != 0 {
+				mode = "executable"
+			}
+		default:
+			return nil
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %s %s\n", fileHash, mode, filepath.ToSlash(rel)))
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+
+	return hashBytes([]byte(strings.Join(lines, ""))), nil
+}
+
+// matchesTreePattern returns true if a slash separated relative path
+// matches any of the patterns. Patterns use path.Match syntax. Patterns
+// without a '/' are also matched against the path's last element, so
+// that they match at any depth.
+func matchesTreePattern(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(rel)); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MercurialInfo describes the state of the Mercurial repository
+// containing the packer template.
+type MercurialInfo struct {
+	// Revision is the full changeset ID of the working directory's
+	// parent.
+	Revision string `json:"revision"`
+
+	// Branch is the current named branch.
+	Branch string `json:"branch"`
+
+	// Tags are the sorted tags of the working directory's parent,
+	// excluding 'tip'.
+	Tags []string `json:"tags"`
+
+	// Remote is the 'default' path with any credentials removed.
+	// It is empty if there is no 'default' path.
+	Remote string `json:"remote"`
+
+	// Author is the changeset's author, and AuthorDate is the date
+	// it was committed (RFC 3339).
+	Author     string `json:"author"`
+	AuthorDate string `json:"author_date"`
+
+	// Dirty is true if the working directory has uncommitted changes.
+	Dirty bool `json:"dirty"`
+
+	// ModifiedFiles and UntrackedFiles are the sorted paths (relative
+	// to the repository root) of the files that have uncommitted
+	// changes, and of the files that are not tracked. Ignored files
+	// are not included.
+	ModifiedFiles  []string `json:"modified_files"`
+	UntrackedFiles []string `json:"untracked_files"`
+}
+
+type gitDetector struct{}
+
+func (o *gitDetector) name() string {
+	return GitVcs
+}
+
+func (o *gitDetector) detect(dirPath string) bool {
+	raw, err := gitOutput(dirPath, nil, "rev-parse", "--is-inside-work-tree")
+
+	return err == nil && string(bytes.TrimSpace(raw)) == "true"
+}
+
+func (o *gitDetector) record(dirPath string, manifest *Manifest, config *PluginConfig) error {
+	gitRev, err := currentGitRevision(dirPath)
+	if err != nil {
+		return err
+	}
+
+	gitInfo, err := currentGitInfo(dirPath)
+	if err != nil {
+		return err
+	}
+
+	var gitDiff []byte
+
+	if config.SaveGitDiff && gitInfo.Dirty {
+		maxSizeBytes := config.SaveFileSizeBytes
+		if maxSizeBytes <= 0 {
+			maxSizeBytes = math.MaxInt64 - 1
+		}
+
+		gitDiff, err = gitWorkingTreeDiff(dirPath, maxSizeBytes)
+		if err == errExceedsMaxSize {
+			return fmt.Errorf("git diff exceeds the maximum of %d bytes", maxSizeBytes)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The diff can contain secrets that were committed to
+		// the working tree, such as a variables file.
+		gitDiff = []byte(redactText(string(gitDiff), manifest.secrets))
+	}
+
+	manifest.GitRevision = gitRev
+	manifest.Git = gitInfo
+	manifest.gitDiff = gitDiff
+
+	return nil
+}
+
+type mercurialDetector struct{}
+
+func (o *mercurialDetector) name() string {
+	return MercurialVcs
+}
+
+func (o *mercurialDetector) detect(dirPath string) bool {
+	_, err := hgOutput(dirPath, "root")
+
+	return err == nil
+}
+
+func (o *mercurialDetector) record(dirPath string, manifest *Manifest, config *PluginConfig) error {
+	raw, err := hgOutput(dirPath, "log", "-r", ".", "--template",
+		"{node}\\n{branch}\\n{tags}\\n{author}\\n{date|rfc3339date}\\n")
+	if err != nil {
+		return err
+	}
+
+	info, err := parseHgLog(string(raw))
+	if err != nil {
+		return err
+	}
+
+	raw, err = hgOutput(dirPath, "paths", "default")
+	if err == nil {
+		info.Remote = stripUrlCredentials(string(bytes.TrimSpace(raw)))
+	}
+
+	// Paths are relative to the repository root when HGPLAIN is set.
+	raw, err = hgOutput(dirPath, "status")
+	if err != nil {
+		return err
+	}
+
+	info.ModifiedFiles, info.UntrackedFiles = parseHgStatus(string(raw))
+	info.Dirty = len(info.ModifiedFiles) > 0 || len(info.UntrackedFiles) > 0
+
+	manifest.Mercurial = info
+
+	return nil
+}
+
+func hgOutput(dirPath string, args ...string) ([]byte, error) {
+	hg := exec.Command("hg", args...)
+	hg.Dir = dirPath
+	// Disable user configuration (e.g., aliases and localization)
+	// that could change the output.
+	hg.Env = append(os.Environ(), "HGPLAIN=1")
+
+	stderr := bytes.NewBuffer(nil)
+	hg.Stderr = stderr
+
+	raw, err := hg.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run hg %s - %s - stderr: '%s'",
+			strings.Join(args, " "), err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return raw, nil
+}
+
+// parseHgLog parses the output of the 'hg log' template used by
+// mercurialDetector.
+func parseHgLog(s string) (*MercurialInfo, error) {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	if len(lines) < 5 {
+		return nil, fmt.Errorf("failed to parse hg log output - '%s'", s)
+	}
+
+	info := &MercurialInfo{
+		Revision:       strings.TrimSpace(lines[0]),
+		Branch:         strings.TrimSpace(lines[1]),
+		Tags:           []string{},
+		Author:         strings.TrimSpace(lines[3]),
+		AuthorDate:     strings.TrimSpace(lines[4]),
+		ModifiedFiles:  []string{},
+		UntrackedFiles: []string{},
+	}
+
+	if len(strings.Trim(info.Revision, "0")) == 0 {
+		return nil, fmt.Errorf("failed to get current mercurial revision; verify that at least one commit has been created")
+	}
+
+	for _, tag := range strings.Fields(lines[2]) {
+		if tag != "tip" {
+			info.Tags = append(info.Tags, tag)
+		}
+	}
+
+	sort.Strings(info.Tags)
+
+	return info, nil
+}
+
+// parseHgStatus parses the output of 'hg status', and returns the
+// sorted paths of modified and untracked files.
+func parseHgStatus(s string) ([]string, []string) {
+	modified := []string{}
+	untracked := []string{}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 3 || line[1] != ' ' {
+			continue
+		}
+
+		filePath := line[2:]
+
+		switch line[0] {
+		case '?':
+			untracked = append(untracked, filePath)
+		case 'I', ' ':
+			// Ignored files and copy sources.
+		default:
+			modified = append(modified, filePath)
+		}
+	}
+
+	sort.Strings(modified)
+	sort.Strings(untracked)
+
+	return modified, untracked
+}
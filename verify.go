@@ -0,0 +1,217 @@
+package breadcrumbs
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// VerifyResult describes the differences between the files recorded in
+// a breadcrumbs manifest and the files found in the breadcrumbs directory.
+type VerifyResult struct {
+	// Missing contains the files that are recorded in the manifest,
+	// but do not exist in the breadcrumbs directory.
+	Missing []FileMeta `json:"missing"`
+
+	// Modified contains the files whose contents do not match the
+	// hashes recorded in the manifest. If the provenance attestation's
+	// subject does not match the manifest, the attestation is included
+	// with the subject's digest as its Sha256, and the manifest's hash
+	// as its ActualSha256.
+	Modified []ModifiedFile `json:"modified"`
+
+	// Unverifiable contains the files that exist, but have no
+	// recorded hash (e.g., because the manifest was created by an
+	// older version of the plugin).
+	Unverifiable []FileMeta `json:"unverifiable"`
+
+	// Extra contains the paths (relative to the breadcrumbs directory)
+	// of files that are not recorded in the manifest.
+	Extra []string `json:"extra"`
+
+	// Signature is the result of verifying the manifest's signature.
+	// It is empty if the signature was not checked.
+	Signature SignatureStatus `json:"signature,omitempty"`
+}
+
+// ModifiedFile is a file whose contents do not match its recorded hash.
+type ModifiedFile struct {
+	FileMeta
+	ActualSha256 string `json:"actual_sha256"`
+	ActualSha512 string `json:"actual_sha512,omitempty"`
+}
+
+// Ok returns true if no missing, modified, or extra files were found,
+// and the signature (if checked) is valid.
+func (o VerifyResult) Ok() bool {
+	if len(o.Signature) > 0 && o.Signature != ValidSignature {
+		return false
+	}
+
+	return len(o.Missing) == 0 && len(o.Modified) == 0 && len(o.Extra) == 0
+}
+
+// OkStrict is like Ok, but also returns false if any files
+// are unverifiable.
+func (o VerifyResult) OkStrict() bool {
+	return o.Ok() && len(o.Unverifiable) == 0
+}
+
+// VerifyDir verifies the files in a breadcrumbs directory (or an archive
+// of one) against the content hashes recorded in its manifest.
+func VerifyDir(breadcrumbsPath string) (*VerifyResult, error) {
+	b, err := Open(breadcrumbsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	return Verify(b)
+}
+
+// Verify verifies the files in an opened breadcrumbs directory or
+// archive against the content hashes recorded in its manifest.
+func Verify(b *Breadcrumbs) (*VerifyResult, error) {
+	result := &VerifyResult{}
+
+	// The signature and provenance attestation cannot be recorded
+	// in the manifest because the manifest is their subject.
+	known := map[string]bool{
+		ManifestFileName:   true,
+		SignatureFileName:  true,
+		ProvenanceFileName: true,
+	}
+
+	var metas []FileMeta
+	metas = append(metas, b.Manifest.TemplateFiles...)
+	metas = append(metas, b.Manifest.FoundFiles...)
+	metas = append(metas, b.Manifest.GeneratedFiles...)
+
+	for _, meta := range metas {
+		known[path.Clean(filepath.ToSlash(meta.StoredAtPath))] = true
+
+		if len(meta.Sha256) == 0 {
+			exists, err := storedFileExists(b, meta)
+			if err != nil {
+				return nil, err
+			}
+
+			if exists {
+				result.Unverifiable = append(result.Unverifiable, meta)
+			} else {
+				result.Missing = append(result.Missing, meta)
+			}
+			continue
+		}
+
+		actual, err := hashStoredFile(b, meta)
+		if err != nil {
+			if os.IsNotExist(err) {
+				result.Missing = append(result.Missing, meta)
+				continue
+			}
+			return nil, err
+		}
+
+		if actual.sha256 != meta.Sha256 || actual.sha512 != meta.Sha512 {
+			result.Modified = append(result.Modified, ModifiedFile{
+				FileMeta:     meta,
+				ActualSha256: actual.sha256,
+				ActualSha512: actual.sha512,
+			})
+		}
+	}
+
+	modified, err := verifyProvenance(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if modified != nil {
+		result.Modified = append(result.Modified, *modified)
+	}
+
+	files, err := b.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range files {
+		if !known[name] {
+			result.Extra = append(result.Extra, name)
+		}
+	}
+
+	return result, nil
+}
+
+// verifyProvenance checks that the subject of the provenance attestation
+// (if there is one) is the manifest. A ModifiedFile describing the
+// attestation is returned if it is not.
+func verifyProvenance(b *Breadcrumbs) (*ModifiedFile, error) {
+	provenanceMeta := FileMeta{
+		Name:         ProvenanceFileName,
+		StoredAtPath: ProvenanceFileName,
+		Source:       Generated,
+	}
+
+	raw, err := readStoredFile(b, provenanceMeta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	manifestJson, err := readStoredFile(b, FileMeta{StoredAtPath: ManifestFileName})
+	if err != nil {
+		return nil, err
+	}
+
+	actual := hashBytes(manifestJson)
+
+	// An attestation that cannot be decoded is reported
+	// as modified with an empty digest.
+	var statement inTotoStatement
+	if json.Unmarshal(raw, &statement) == nil {
+		for _, subject := range statement.Subject {
+			if subject.Name == ManifestFileName {
+				provenanceMeta.Sha256 = subject.Digest["sha256"]
+				break
+			}
+		}
+	}
+
+	if provenanceMeta.Sha256 == actual {
+		return nil, nil
+	}
+
+	return &ModifiedFile{
+		FileMeta:     provenanceMeta,
+		ActualSha256: actual,
+	}, nil
+}
+
+func storedFileExists(b *Breadcrumbs, meta FileMeta) (bool, error) {
+	r, err := b.OpenFile(meta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	r.Close()
+
+	return true, nil
+}
+
+func hashStoredFile(b *Breadcrumbs, meta FileMeta) (savedFile, error) {
+	r, err := b.OpenFile(meta)
+	if err != nil {
+		return savedFile{}, err
+	}
+	defer r.Close()
+
+	return hashReader(r, len(meta.Sha512) > 0)
+}
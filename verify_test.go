@@ -0,0 +1,376 @@
+package breadcrumbs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+func TestVerifyDir(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	result, err := VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !result.Ok() {
+		t.Fatalf("unmodified breadcrumbs should verify - got %+v", result)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootDirPath, manifest.FoundFiles[0].StoredAtPath), []byte("tampered"), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = os.Remove(filepath.Join(rootDirPath, manifest.FoundFiles[1].StoredAtPath))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootDirPath, "extra.sh"), []byte("extra"), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if result.Ok() {
+		t.Fatal("tampered breadcrumbs should not verify")
+	}
+
+	if len(result.Modified) != 1 || result.Modified[0].Name != "a.sh" {
+		t.Fatalf("expected a.sh to be modified - got %+v", result.Modified)
+	}
+
+	if len(result.Missing) != 1 || result.Missing[0].Name != "b.sh" {
+		t.Fatalf("expected b.sh to be missing - got %+v", result.Missing)
+	}
+
+	if len(result.Extra) != 1 || result.Extra[0] != "extra.sh" {
+		t.Fatalf("expected extra.sh to be extra - got %+v", result.Extra)
+	}
+}
+
+func TestVerifyDirUnverifiable(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	// Manifests created by older versions of the plugin
+	// did not record hashes.
+	for i := range manifest.FoundFiles {
+		manifest.FoundFiles[i].Sha256 = ""
+	}
+
+	manifestJson, err := manifest.ToJson()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootDirPath, ManifestFileName), manifestJson, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = os.Remove(filepath.Join(rootDirPath, manifest.FoundFiles[1].StoredAtPath))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(result.Unverifiable) != 1 || result.Unverifiable[0].Name != "a.sh" {
+		t.Fatalf("expected a.sh to be unverifiable - got %+v", result.Unverifiable)
+	}
+
+	if len(result.Missing) != 1 || result.Missing[0].Name != "b.sh" {
+		t.Fatalf("expected b.sh to be missing even though it has no hash - got %+v", result.Missing)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootDirPath, manifest.FoundFiles[1].StoredAtPath), []byte("echo b.sh\n"), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !result.Ok() {
+		t.Fatalf("unverifiable files should not fail verification - got %+v", result)
+	}
+
+	if result.OkStrict() {
+		t.Fatal("unverifiable files should fail strict verification")
+	}
+}
+
+func TestVerifyDirProvenance(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	manifestPath := filepath.Join(rootDirPath, ManifestFileName)
+
+	manifestJson, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	provenance, err := newProvenance(manifest, manifestJson, time.Now())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootDirPath, ProvenanceFileName), provenance, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !result.Ok() {
+		t.Fatalf("the provenance's subject should match the manifest - got %+v", result)
+	}
+
+	err = ioutil.WriteFile(manifestPath, append(manifestJson, '\n'), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(result.Modified) != 1 || result.Modified[0].StoredAtPath != ProvenanceFileName ||
+		result.Modified[0].Sha256 != hashBytes(manifestJson) ||
+		result.Modified[0].ActualSha256 != hashBytes(append(manifestJson, '\n')) {
+		t.Fatalf("expected the provenance to be modified - got %+v", result.Modified)
+	}
+}
+
+// newTestBreadcrumbs creates a breadcrumbs directory containing a
+// template and two local files ('a.sh' and 'b.sh'). The caller must
+// remove the parent of the returned directory.
+func newTestBreadcrumbs(t *testing.T) (string, *Manifest) {
+	dirPath, err := ioutil.TempDir("", "breadcrumbs-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var foundFiles []FileMeta
+
+	for _, name := range []string{"a.sh", "b.sh"} {
+		filePath := filepath.Join(dirPath, name)
+
+		err := ioutil.WriteFile(filePath, []byte("echo "+name+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		foundFiles = append(foundFiles, newFileMeta(filePath))
+	}
+
+	template := templateFile{
+		path: filepath.Join(dirPath, "template.json"),
+		raw:  positiveTestFileContents,
+	}
+	templateMeta := newTemplateFileMeta(template)
+
+	manifest := &Manifest{
+		SchemaVersion:  ManifestSchemaVersion,
+		PackerTemplate: templateMeta.StoredAtPath,
+		TemplateFormat: JsonTemplate,
+		TemplateFiles:  []FileMeta{templateMeta},
+		FoundFiles:     foundFiles,
+		templatesRaw: map[string][]byte{
+			templateMeta.StoredAtPath: template.raw,
+		},
+	}
+
+	rootDirPath := filepath.Join(dirPath, "breadcrumbs")
+
+	err = createBreadcrumbs(context.Background(), packer.TestUi(t), rootDirPath, manifest, &PluginConfig{
+		SaveFileSizeBytes: defaultSaveFileSizeBytes,
+	})
+	if err != nil {
+		os.RemoveAll(dirPath)
+		t.Fatal(err.Error())
+	}
+
+	return rootDirPath, manifest
+}
+
+func TestVerifySignature(t *testing.T) {
+	rootDirPath, manifest := newTestBreadcrumbs(t)
+	defer os.RemoveAll(filepath.Dir(rootDirPath))
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	keyPath := filepath.Join(filepath.Dir(rootDirPath), "signing.pem")
+	writeTestPem(t, keyPath, "PRIVATE KEY", privateKey, x509.MarshalPKCS8PrivateKey)
+
+	publicKeyPath := filepath.Join(filepath.Dir(rootDirPath), "signing.pub.pem")
+	writeTestPem(t, publicKeyPath, "PUBLIC KEY", publicKey, x509.MarshalPKIXPublicKey)
+
+	err = createBreadcrumbs(context.Background(), packer.TestUi(t), rootDirPath, manifest, &PluginConfig{
+		SaveFileSizeBytes: defaultSaveFileSizeBytes,
+		SigningKeyPath:    keyPath,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	loadedPublicKey, err := LoadPublicKey(publicKeyPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	checkSignature := func(publicKey ed25519.PublicKey, expected SignatureStatus) {
+		b, err := Open(rootDirPath)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer b.Close()
+
+		status, err := VerifySignature(b, publicKey)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if status != expected {
+			t.Fatalf("signature should be '%s' - got '%s'", expected, status)
+		}
+	}
+
+	checkSignature(loadedPublicKey, ValidSignature)
+	checkSignature(otherPublicKey, InvalidSignature)
+
+	result, err := VerifyDir(rootDirPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !result.Ok() {
+		t.Fatalf("the signature file should not be reported as an extra file - got %+v", result)
+	}
+
+	manifestPath := filepath.Join(rootDirPath, ManifestFileName)
+	raw, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(manifestPath, append(raw, ' '), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	checkSignature(loadedPublicKey, InvalidSignature)
+
+	err = os.Remove(filepath.Join(rootDirPath, SignatureFileName))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	checkSignature(loadedPublicKey, MissingSignature)
+}
+
+func TestLoadSshKeys(t *testing.T) {
+	_, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	dirPath, err := ioutil.TempDir("", "breadcrumbs-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dirPath)
+
+	keyPath := filepath.Join(dirPath, "id_ed25519")
+
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to generate ssh key - %s - output: %s", err.Error(), out)
+	}
+
+	privateKey, err := loadSigningKey(keyPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	publicKey, err := LoadPublicKey(keyPath + ".pub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	message := []byte("breadcrumbs")
+	if !ed25519.Verify(publicKey, message, ed25519.Sign(privateKey, message)) {
+		t.Fatal("the ssh public key should verify signatures made by the ssh private key")
+	}
+}
+
+func TestLoadSigningKeyRejectsOtherKeyTypes(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "breadcrumbs-test-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dirPath)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	keyPath := filepath.Join(dirPath, "ecdsa.pem")
+	writeTestPem(t, keyPath, "PRIVATE KEY", privateKey, x509.MarshalPKCS8PrivateKey)
+
+	_, err = loadSigningKey(keyPath)
+	if err == nil {
+		t.Fatal("loading an ecdsa key should fail")
+	}
+}
+
+func writeTestPem(t *testing.T, filePath string, pemType string, key interface{}, marshal func(interface{}) ([]byte, error)) {
+	der, err := marshal(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(filePath, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
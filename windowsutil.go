@@ -0,0 +1,207 @@
+package breadcrumbs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/hashicorp/packer/packer"
+)
+
+// windowsFactsScript is a PowerShell script that prints facts about a
+// Windows machine as a JSON object. CIM is used rather than WMI so that
+// the script works on PowerShell Core. The registry is used for values
+// that CIM does not provide, such as the update build revision (UBR).
+const windowsFactsScript = `$ErrorActionPreference = 'Stop'
+$ProgressPreference = 'SilentlyContinue'
+$os = Get-CimInstance -ClassName Win32_OperatingSystem
+$cv = Get-ItemProperty -Path 'HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion'
+$cs = Get-CimInstance -ClassName Win32_ComputerSystem
+$hotfixes = @(Get-CimInstance -ClassName Win32_QuickFixEngineering | ForEach-Object { [string]$_.HotFixID })
+[pscustomobject]@{
+    caption = [string]$os.Caption
+    version = [string]$os.Version
+    edition_id = [string]$cv.EditionID
+    installation_type = [string]$cv.InstallationType
+    display_version = [string]$cv.DisplayVersion
+    build_number = [string]$os.BuildNumber
+    ubr = [int]$cv.UBR
+    architecture = [string]$os.OSArchitecture
+    processor_architecture = [string]$env:PROCESSOR_ARCHITECTURE
+    firmware_type = [string]$env:firmware_type
+    manufacturer = [string]$cs.Manufacturer
+    model = [string]$cs.Model
+    hotfixes = $hotfixes
+    locale = [string](Get-Culture).Name
+} | ConvertTo-Json -Compress
+`
+
+// WindowsFacts describes a Windows machine.
+type WindowsFacts struct {
+	// Caption is the product name (e.g., 'Microsoft Windows Server
+	// 2019 Datacenter').
+	Caption string `json:"caption"`
+
+	// Version is the OS version (e.g., '10.0.17763').
+	Version string `json:"version"`
+
+	// EditionID is the edition (e.g., 'ServerDatacenter').
+	EditionID string `json:"edition_id"`
+
+	// InstallationType is 'Server Core' for Server Core installations,
+	// 'Server' for Desktop Experience installations, and 'Client' for
+	// non-server installations.
+	InstallationType string `json:"installation_type"`
+
+	// DisplayVersion is the feature update version (e.g., '21H2').
+	// It is empty for older versions of Windows.
+	DisplayVersion string `json:"display_version,omitempty"`
+
+	// BuildNumber is the OS build number (e.g., '17763').
+	BuildNumber string `json:"build_number"`
+
+	// UBR is the update build revision, which identifies the
+	// cumulative update (i.e., patch level) that is installed.
+	UBR int `json:"ubr"`
+
+	// Architecture is the OS architecture (e.g., '64-bit').
+	Architecture string `json:"architecture"`
+
+	// ProcessorArchitecture is the processor architecture
+	// (e.g., 'AMD64' or 'ARM64').
+	ProcessorArchitecture string `json:"processor_architecture"`
+
+	// FirmwareType is 'UEFI' or 'Legacy'. It is empty for versions
+	// of Windows older than Windows 8 and Windows Server 2012.
+	FirmwareType string `json:"firmware_type,omitempty"`
+
+	// Manufacturer and Model describe the computer system
+	// (e.g., 'VMware, Inc.' and 'VMware7,1').
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+
+	// Hotfixes is the sorted list of installed hotfix IDs
+	// (e.g., 'KB5005112').
+	Hotfixes []string `json:"hotfixes"`
+
+	// Locale is the culture name (e.g., 'en-US').
+	Locale string `json:"locale"`
+}
+
+// FullVersion returns the version including the build revision
+// (e.g., '10.0.17763.5206').
+func (o WindowsFacts) FullVersion() string {
+	if o.UBR == 0 {
+		return o.Version
+	}
+
+	return o.Version + "." + strconv.Itoa(o.UBR)
+}
+
+// addWindowsSystemFacts adds the kernel release, architecture,
+// virtualization type, and boot mode of a Windows machine to
+// optionalFields.
+func addWindowsSystemFacts(facts *WindowsFacts, optionalFields *OptionalManifestFields) {
+	optionalFields.KernelRelease = facts.Version
+	optionalFields.Architecture = facts.ProcessorArchitecture
+	optionalFields.Virtualization = windowsVirtualization(facts.Manufacturer, facts.Model)
+
+	switch strings.ToLower(facts.FirmwareType) {
+	case "uefi":
+		optionalFields.BootMode = "uefi"
+	case "legacy":
+		optionalFields.BootMode = "bios"
+	}
+}
+
+// windowsVirtualization returns the virtualization type based on the
+// computer system's manufacturer and model. The same names are used
+// as 'systemd-detect-virt'. An empty string is returned if the type
+// cannot be determined.
+func windowsVirtualization(manufacturer string, model string) string {
+	manufacturer = strings.ToLower(manufacturer)
+	model = strings.ToLower(model)
+
+	switch {
+	case strings.Contains(manufacturer, "vmware"):
+		return "vmware"
+	case strings.Contains(manufacturer, "innotek"), strings.Contains(model, "virtualbox"):
+		return "oracle"
+	case strings.Contains(manufacturer, "qemu"), strings.Contains(model, "kvm"):
+		return "kvm"
+	case strings.Contains(manufacturer, "xen"):
+		return "xen"
+	case strings.Contains(manufacturer, "parallels"):
+		return "parallels"
+	case strings.Contains(manufacturer, "amazon ec2"):
+		return "amazon"
+	case strings.Contains(manufacturer, "google"):
+		return "google"
+	case strings.Contains(manufacturer, "microsoft") && strings.Contains(model, "virtual machine"):
+		return "microsoft"
+	}
+
+	return ""
+}
+
+// encodePowerShellCommand encodes a script for use with PowerShell's
+// '-EncodedCommand' argument, which avoids quoting issues with the
+// Windows command line.
+func encodePowerShellCommand(script string) string {
+	encoded := utf16.Encode([]rune(script))
+
+	raw := make([]byte, len(encoded)*2)
+	for i, u := range encoded {
+		binary.LittleEndian.PutUint16(raw[i*2:], u)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func windowsFacts(ctx context.Context, c packer.Communicator) (*WindowsFacts, error) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	ps := &packer.RemoteCmd{
+		Command: "powershell -NoProfile -NonInteractive -EncodedCommand " + encodePowerShellCommand(windowsFactsScript),
+		Stdout:  stdout,
+		Stderr:  stderr,
+	}
+
+	err := runRemoteCmd(ctx, c, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	if ps.ExitStatus() != 0 {
+		return nil, fmt.Errorf("powershell exited with status %d - stderr: '%s'",
+			ps.ExitStatus(), strings.TrimSpace(stderr.String()))
+	}
+
+	return parseWindowsFacts(stdout.String())
+}
+
+func parseWindowsFacts(s string) (*WindowsFacts, error) {
+	// The output may be preceded by a byte order mark or other
+	// noise, so skip everything before the JSON object.
+	start := strings.Index(s, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("powershell output does not contain a json object - '%s'", strings.TrimSpace(s))
+	}
+
+	var facts WindowsFacts
+	err := json.NewDecoder(strings.NewReader(s[start:])).Decode(&facts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse windows facts - %s", err.Error())
+	}
+
+	sort.Strings(facts.Hotfixes)
+
+	return &facts, nil
+}